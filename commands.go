@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+)
+
+// botCommand is a request the bot responds to.  The help text is generated from the commands, so a new
+// command, or argument, is listed in it automatically.
+type botCommand struct {
+	// keyword selects the command, when the message contains it
+	keyword string
+	// usage is how the command is invoked, e.g. "status STREAM"
+	usage       string
+	description string
+	args        []botArg
+	// run returns the reply to the message, and if set a longer message to post in the reply's thread
+	run func(ctx context.Context, event Event) (string, string)
+}
+
+// botArg is an argument accepted by a botCommand.
+type botArg struct {
+	// usage is how the argument is given, e.g. "min=X"
+	usage       string
+	description string
+}
+
+// commands returns the bot's commands, in the order they are matched against a message and listed in the help.
+func (o *options) commands() []botCommand {
+	archArg := botArg{"arch=X", fmt.Sprintf("look at architecture X, where X is one of [%s]", strings.Join(o.architectures(), ", "))}
+	return []botCommand{
+		{
+			keyword:     "help",
+			usage:       "help",
+			description: "this help text",
+			run: func(ctx context.Context, event Event) (string, string) {
+				return o.helpText(), ""
+			},
+		},
+		{
+			keyword:     "status",
+			usage:       "status STREAM",
+			description: "Reports on a single release stream, e.g. *status 4.15.0-0.nightly*, including the checks it passed.",
+			args:        []botArg{archArg},
+			run: func(ctx context.Context, event Event) (string, string) {
+				statusOptions := *o
+				stream := ""
+				args := strings.Split(event.Text, " ")
+				for i, arg := range args {
+					if arg == "status" && i+1 < len(args) {
+						stream = args[i+1]
+					}
+					if strings.HasPrefix(arg, "arch=") {
+						statusOptions.Arch = strings.TrimPrefix(arg, "arch=")
+					}
+				}
+				return statusOptions.streamStatusMessage(ctx, stream), ""
+			},
+		},
+		{
+			keyword:     "details",
+			usage:       "details STREAM",
+			description: "Replied in the thread of a report, posts every payload of the stream with its age and the upgrades to it, e.g. *details 4.15.0-0.nightly*.",
+			run: func(ctx context.Context, event Event) (string, string) {
+				if event.ThreadTS == "" {
+					return "Sorry, *details* has to be a reply in the thread of a report", ""
+				}
+				stream := ""
+				args := strings.Fields(event.Text)
+				for i, arg := range args {
+					if arg == "details" && i+1 < len(args) {
+						stream = args[i+1]
+					}
+				}
+				return o.streamDetailsMessages(ctx, stream, event.ThreadTS)
+			},
+		},
+		{
+			keyword:     "report",
+			usage:       "report",
+			description: "Generates human reports about which release streams do not have recently built or recently accepted payloads, based on the release info found at https://amd64.ocp.releases.ci.openshift.org/ or the equivalent page for the architecture specified in the request.",
+			args: []botArg{
+				{"min=X", "only look at z-streams with a minimum version of X, e.g. *min=9*"},
+				{"max=X", "only look at z-streams with a maximum version of X, e.g. *max=12*"},
+				archArg,
+				{"include=X", "only look at streams matching the glob pattern X, e.g. *include=4.*.0-0.nightly*.  May be repeated."},
+				{"exclude=X", "ignore streams matching the glob pattern X, e.g. *exclude=4.15.0-0.ci*.  May be repeated."},
+				{"api=X", "report on the release controller at url X instead of the one for the architecture, e.g. a staging controller.  Not available when the bot authenticates with an --api-token."},
+				{"healthy", "include healthy z-streams in the report"},
+				{"tag", "tag patch manager with the report output"},
+			},
+			run: func(ctx context.Context, event Event) (string, string) {
+				reportOptions, tagPatchManager, err := o.parseReportArgs(event.Text)
+				if err != nil {
+					return err.Error(), ""
+				}
+				if err := reportOptions.validate(); err != nil {
+					return fmt.Sprintf("Sorry, I can't generate a report with those arguments: %v", err), ""
+				}
+				// follow-up requests in the report's thread use the same options
+				mutex.Lock()
+				threadOptions[event.replyThread()] = threadReport{options: reportOptions, requested: time.Now()}
+				mutex.Unlock()
+				return reportOptions.reportMessages(ctx, tagPatchManager)
+			},
+		},
+	}
+}
+
+// architectures returns the architectures which have a release controller for the flavor, in alphabetical order.
+func (o *options) architectures() []string {
+	flavor, _ := watcher.FlavorByName(o.Flavor)
+	archs := []string{}
+	for arch := range flavor.ReleaseAPIUrls {
+		archs = append(archs, "*"+arch+"*")
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// helpText lists the bot's commands and their arguments, followed by the current settings.
+func (o *options) helpText() string {
+	output := ""
+	for _, c := range o.commands() {
+		output += fmt.Sprintf("*%s* - %s\n", c.usage, c.description)
+		if len(c.args) == 0 {
+			continue
+		}
+		output += "Arguments:\n"
+		for _, a := range c.args {
+			output += fmt.Sprintf("  *%s* - %s\n", a.usage, a.description)
+		}
+	}
+
+	builtStalenessOverrides := ""
+	if len(o.BuiltStalenessByMinor) > 0 {
+		builtStalenessOverrides = fmt.Sprintf(" (per minor overrides: *%s*)", o.BuiltStalenessByMinor.String())
+	}
+	output += fmt.Sprintf(`Current settings/defaults:
+  Accepted payloads must be newer than *%0.1f* hours
+  Payloads must have been built within the last *%0.1f* hours%s
+  Default: Included releases are >=*%s* and <=*%s*
+  Default: Architecture is *%s*
+  Default: Fully healthy z-streams are not included in the report`, o.AcceptedStalenessLimit.Hours(), o.BuiltStalenessLimit.Hours(), builtStalenessOverrides, o.Scheme().FormatMinor(o.OldestMinor), o.Scheme().FormatMinor(o.NewestMinor), o.Arch)
+	return output
+}
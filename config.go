@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+	"github.com/spf13/pflag"
+)
+
+// redactedFlags are the flags holding secrets, whose values are never printed.
+var redactedFlags = map[string]bool{
+	"api-token":             true,
+	"pagerduty-routing-key": true,
+	// webhook headers often carry credentials
+	"webhook-header": true,
+}
+
+// writeConfig writes the value of every flag, followed by the settings derived from them, so a deployment's
+// effective configuration can be checked.
+func (o *options) writeConfig(w io.Writer, flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "print-config" || f.Name == "help" {
+			return
+		}
+		value := f.Value.String()
+		if redactedFlags[f.Name] && value != f.DefValue {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(w, "%s: %s\n", f.Name, value)
+	})
+
+	flavor, _ := watcher.FlavorByName(o.Flavor)
+	releaseAPIUrl, found := flavor.ReleaseAPIUrls[o.Arch]
+	if o.ReleaseAPIUrl != "" {
+		releaseAPIUrl = o.ReleaseAPIUrl
+	} else if !found {
+		releaseAPIUrl = "<unknown architecture>"
+	}
+	fmt.Fprintf(w, "release API url: %s\n", releaseAPIUrl)
+	if o.OldestMinor == -1 || o.NewestMinor == -1 {
+		fmt.Fprintf(w, "supported releases url: %s\n", watcher.LifeCycleUrl)
+	}
+	if o.pagerDutyRoutingKey != "" {
+		fmt.Fprintf(w, "PagerDuty events url: %s\n", pagerDutyEventsUrl)
+	}
+	// only the bot posts to Slack
+	if flags.Lookup("token-file") != nil {
+		source := "TOKEN environment variable"
+		set := os.Getenv("TOKEN") != ""
+		if o.tokenFile != "" {
+			source = "token file " + o.tokenFile
+			_, err := readTokenFile(o.tokenFile)
+			set = err == nil
+		}
+		if set {
+			fmt.Fprintf(w, "Slack token: <redacted>, from the %s\n", source)
+		} else {
+			fmt.Fprintf(w, "Slack token: not set, expected in the %s\n", source)
+		}
+	}
+}
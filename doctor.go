@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+	"github.com/spf13/cobra"
+)
+
+const (
+	slackAuthTestUrl          = "https://slack.com/api/auth.test"
+	slackConversationsInfoUrl = "https://slack.com/api/conversations.info"
+)
+
+// doctorCheck is one of the checks run by the doctor command.
+type doctorCheck struct {
+	name string
+	// run returns a short description of what was found, or an error if the check failed
+	run func(ctx context.Context) (string, error)
+}
+
+// SlackResponse is the part of every Slack API response which reports whether the call succeeded.
+type SlackResponse struct {
+	OK bool `json:"ok"`
+	// Error is the Slack error code when OK is false, e.g. "invalid_auth"
+	Error string `json:"error"`
+}
+
+type AuthTestResponse struct {
+	SlackResponse
+	Team string `json:"team"`
+	User string `json:"user"`
+}
+
+type ConversationsInfoResponse struct {
+	SlackResponse
+	Channel struct {
+		Name string `json:"name"`
+	} `json:"channel"`
+}
+
+func newDoctorCommand(global *rootOptions) *cobra.Command {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the release API, upgrade graph and Slack are reachable with the given configuration",
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			global.apply(&o.Config)
+			if o.printConfig {
+				o.writeConfig(os.Stdout, cmd.Flags())
+				return nil
+			}
+			return o.runDoctor(cmd.Context(), os.Stdout)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&o.tokenFile, "token-file", "", "File to read the Slack token from.  Defaults to the TOKEN environment variable when unset.")
+	flagset.StringSliceVar(&o.reportChannels, "report-channels", nil, "Slack channel IDs scheduled reports are posted to, which are checked to exist")
+	flagset.StringVar(&o.defaultChannel, "default-channel", "", "Slack channel ID reports are posted to when they aren't a reply to a message, which is checked to exist")
+	addSharedFlags(flagset, o)
+	return cmd
+}
+
+// runDoctor runs each check, printing whether it passed, and returns an error if any of them failed.  The checks
+// all run even after one fails, so every problem is found at once.
+func (o *options) runDoctor(ctx context.Context, w io.Writer) error {
+	failed := 0
+	checks := o.doctorChecks()
+	for _, c := range checks {
+		result, err := c.run(ctx)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s: %s\n", c.name, result)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// doctorChecks returns the checks to run, the Slack channels' only once the token is known to be valid.
+func (o *options) doctorChecks() []doctorCheck {
+	token := ""
+	tokenValid := false
+	checks := []doctorCheck{
+		{
+			name: "configuration",
+			run: func(ctx context.Context) (string, error) {
+				if err := o.validate(); err != nil {
+					return "", err
+				}
+				return "the flags are valid", nil
+			},
+		},
+		{
+			name: "release API",
+			run: func(ctx context.Context) (string, error) {
+				releases, err := watcher.FetchAcceptedReleases(ctx, &o.Config)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("found %d release streams", len(releases)), nil
+			},
+		},
+		{
+			name: "upgrade graph",
+			run: func(ctx context.Context) (string, error) {
+				if o.NoUpgradeCheck {
+					return "not used, --no-upgrade-check is set", nil
+				}
+				graph, err := watcher.FetchUpgradeGraph(ctx, &o.Config)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("found upgrades to %d versions", len(graph)), nil
+			},
+		},
+		{
+			name: "Slack token",
+			run: func(ctx context.Context) (string, error) {
+				var err error
+				if token, err = o.slackToken(); err != nil {
+					return "", err
+				}
+				resp := AuthTestResponse{}
+				if err := callSlackAPI(slackAuthTestUrl, token, neturl.Values{}, &resp); err != nil {
+					return "", err
+				}
+				tokenValid = true
+				return fmt.Sprintf("authenticated as %s in %s", resp.User, resp.Team), nil
+			},
+		},
+	}
+
+	channels := []string{}
+	if o.defaultChannel != "" {
+		channels = append(channels, o.defaultChannel)
+	}
+	for _, channel := range o.reportChannels {
+		if channel != o.defaultChannel {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		channel := channel
+		checks = append(checks, doctorCheck{
+			name: "Slack channel " + channel,
+			run: func(ctx context.Context) (string, error) {
+				if !slackChannelRegex.MatchString(channel) {
+					return "", fmt.Errorf("invalid Slack channel ID, expected an ID like C0123ABCD rather than a channel name")
+				}
+				if !tokenValid {
+					return "", fmt.Errorf("can't be looked up without a valid Slack token")
+				}
+				resp := ConversationsInfoResponse{}
+				if err := callSlackAPI(slackConversationsInfoUrl, token, neturl.Values{"channel": {channel}}, &resp); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("found #%s", resp.Channel.Name), nil
+			},
+		})
+	}
+	return checks
+}
+
+// slackToken returns the Slack token the bot would use, from the token file if one is set.
+func (o *options) slackToken() (string, error) {
+	if o.tokenFile != "" {
+		return readTokenFile(o.tokenFile)
+	}
+	token := os.Getenv("TOKEN")
+	if token == "" {
+		return "", fmt.Errorf("no token, set --token-file or the TOKEN environment variable")
+	}
+	return token, nil
+}
+
+// callSlackAPI calls the Slack API method at url with the form, decoding the response into resp, which must
+// embed a SlackResponse.  An error is returned if Slack reports the call failed.
+func callSlackAPI(url, token string, form neturl.Values, resp interface{}) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	// Slack reports errors in the response body, so the status is only checked for retrying
+	_, body, err := postWithRetry("Slack API "+url, url, []byte(form.Encode()), header, slackLimiter.wait)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("error decoding response from %s: %v", url, err)
+	}
+	status := SlackResponse{}
+	json.Unmarshal(body, &status)
+	if !status.OK {
+		return fmt.Errorf("Slack rejected the request: %s", status.Error)
+	}
+	return nil
+}
@@ -4,10 +4,8 @@ go 1.19
 
 require (
 	github.com/spf13/cobra v1.6.1
+	github.com/spf13/pflag v1.0.5
 	k8s.io/klog v1.0.0
 )
 
-require (
-	github.com/inconshreveable/mousetrap v1.0.1 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-)
+require github.com/inconshreveable/mousetrap v1.0.1 // indirect
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// httpPostTimeout bounds each attempt to post to Slack, PagerDuty or a webhook, so a hung endpoint
+	// can't block the report
+	httpPostTimeout = 30 * time.Second
+	// httpMaxAttempts is how many times a post is attempted before giving up, when rate limited or the
+	// post fails with a network or server error
+	httpMaxAttempts = 5
+	// httpRetryBackoff is the delay before retrying a post which failed with a network or server error,
+	// which doubles after each failure
+	httpRetryBackoff = time.Second
+)
+
+var postClient = &http.Client{Timeout: httpPostTimeout}
+
+// isHTTPURL returns true if url is an absolute http or https url.
+func isHTTPURL(url string) bool {
+	u, err := neturl.Parse(url)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// retryAfter returns the delay requested by a Retry-After header in seconds, defaulting to one second.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// postWithRetry posts body to url with the headers, retrying when the post is rate limited or fails with a
+// network or server error.  before, if set, is called before every attempt, e.g. to wait for a rate limiter.
+// what describes the destination in log and error messages.  The status and body of the final response are
+// returned, any other error status is left to the caller to handle.
+func postWithRetry(what, url string, body []byte, header http.Header, before func()) (int, []byte, error) {
+	backoff := httpRetryBackoff
+	// retry waits before the next attempt, returning false once no attempts remain
+	retry := func(attempt int, delay time.Duration, reason string) bool {
+		if attempt == httpMaxAttempts {
+			klog.Errorf("Giving up posting to %s after %d attempts: %s", what, attempt, reason)
+			return false
+		}
+		klog.Warningf("Error posting to %s (%s), retrying in %s", what, reason, delay)
+		time.Sleep(delay)
+		return true
+	}
+	for attempt := 1; ; attempt++ {
+		if before != nil {
+			before()
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := postClient.Do(req)
+		if err != nil {
+			// the post may have been received before the connection failed, but a rare duplicate is better
+			// than it never arriving
+			if retry(attempt, backoff, err.Error()) {
+				backoff *= 2
+				continue
+			}
+			return 0, nil, fmt.Errorf("error posting to %s: %v", what, err)
+		}
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, nil, fmt.Errorf("error reading response from %s: %v", what, err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if retry(attempt, retryAfter(resp.Header.Get("Retry-After")), "rate limited") {
+				continue
+			}
+			return 0, nil, fmt.Errorf("rate limited posting to %s, gave up after %d attempts", what, attempt)
+		}
+		if resp.StatusCode >= 500 {
+			if retry(attempt, backoff, resp.Status) {
+				backoff *= 2
+				continue
+			}
+			return 0, nil, fmt.Errorf("error posting to %s: %s", what, resp.Status)
+		}
+		return resp.StatusCode, respBody, nil
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+var (
+	// klog header format: Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+	klogHeaderRegex = regexp.MustCompile(`^([IWEF])[0-9]{4} [0-9:.]+\s+[0-9]+ ([^\]]+)\] `)
+
+	klogLevels = map[string]string{
+		"I": "info",
+		"W": "warning",
+		"E": "error",
+		"F": "fatal",
+	}
+)
+
+// jsonLogWriter converts the text lines klog writes into one JSON object per line.
+type jsonLogWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+type jsonLogLine struct {
+	Level   string `json:"level"`
+	Time    string `json:"time"`
+	Caller  string `json:"caller,omitempty"`
+	Message string `json:"msg"`
+}
+
+// Write is called by klog once per log entry, with the header included.
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	entry := jsonLogLine{
+		Level:   "info",
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Message: string(p),
+	}
+	if m := klogHeaderRegex.FindStringSubmatch(entry.Message); m != nil {
+		entry.Level = klogLevels[m[1]]
+		entry.Caller = m[2]
+		entry.Message = entry.Message[len(m[0]):]
+	}
+	entry.Message = strings.TrimRight(entry.Message, "\n")
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setupLogging configures klog to emit log lines in the requested format.
+func setupLogging(flags *flag.FlagSet, format string) error {
+	switch format {
+	case "text":
+		return nil
+	case "json":
+		// route everything through the output writers instead of directly to stderr.
+		flags.Set("logtostderr", "false")
+		flags.Set("alsologtostderr", "false")
+		// higher than any klog severity, so nothing is also written raw to stderr.
+		flags.Set("stderrthreshold", "4")
+
+		// klog writes each entry to the writer for its severity and every lower severity, so
+		// only the info writer emits output; otherwise errors would be logged three times.
+		klog.SetOutput(io.Discard)
+		klog.SetOutputBySeverity("INFO", &jsonLogWriter{out: os.Stderr})
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, must be one of: text, json", format)
+	}
+}
@@ -1,46 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"regexp"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/bparees/release-watcher/pkg/watcher"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/klog"
 )
 
-const (
-	acceptedReleasePath = "/api/v1/releasestreams/accepted"
-	allReleasePath      = "/api/v1/releasestreams/all"
-)
-
-var (
-	// match these two formats:
-	// 4.NNN.0-0.ci
-	// 4.NNN.0-0.nightly
-	zReleaseRegex     = regexp.MustCompile(`4\.([1-9][0-9]*)\.0-0\.(ci|nightly)`)
-	extractMinorRegex = regexp.MustCompile(`4\.([1-9][0-9]*)\.[0-9]+`)
-	// YYYY-MM-DD-HHMMSS
-	extractDateRegex = regexp.MustCompile(`([0-9]{4})-([0-9]{2})-([0-9]{2})-([0-9]{2})([0-9]{2})([0-9]{2})$`)
-
-	releaseAPIUrls = map[string]string{
-		"amd64":   "https://amd64.ocp.releases.ci.openshift.org",
-		"arm64":   "https://arm64.ocp.releases.ci.openshift.org",
-		"multi":   "https://multi.ocp.releases.ci.openshift.org",
-		"ppc64le": "https://ppc64le.ocp.releases.ci.openshift.org",
-		"s390x":   "https://s390x.ocp.releases.ci.openshift.org",
-	}
-)
-
 // TODO
 // add arguments:
 //   args:
 //     release stream api url
 //     oldest minor version to care about
 //     channel/alias to notify in report
-// Sort/format report with sections/headers and sort by release version?
 // What to do with the case: recent builds are newer than a week, but older than a day, so there
 //   will be no recently accepted payload expected, but it also won't be reported as a stale build stream
 // Just ignore them?  (If there are no accepted payloads period, it will still be flagged)
@@ -52,21 +32,64 @@ var (
 //   no build newer than a week exists in the stream - either there have been no changes in the code(ok) or our build system is broken (not ok).  - ????
 
 type options struct {
-	oldestMinor            int
-	newestMinor            int
-	slackAlias             string
-	acceptedStalenessLimit time.Duration
-	builtStalenessLimit    time.Duration
-	upgradeStalenessLimit  time.Duration
-	includeHealthy         bool
-	arch                   string
+	// Config controls how reports are generated
+	watcher.Config
+
+	slackAlias          string
+	includeHealthy      bool
+	schedule            string
+	reportChannel       string
+	reportChannels      []string
+	defaultChannel      string
+	warmup              bool
+	tokenFile           string
+	maxRequestBytes     int64
+	asOf                string
+	webhookURL          string
+	webhookHeaders      []string
+	stateFile           string
+	realertInterval     time.Duration
+	notifyRecoveries    bool
+	pagerDutyRoutingKey string
+	format              string
+	printConfig         bool
+	quiet               bool
+	jsonPretty          bool
+	watch               time.Duration
+	asSnippet           bool
+	snippetThreshold    int
+	reportCacheTTL      time.Duration
+
+	// sendMessage is how the bot posts to Slack
+	sendMessage messageSender
+	// uploadSnippet is how the bot uploads reports to Slack as snippets
+	uploadSnippet snippetUploader
+}
+
+// rootOptions holds the settings of the root command's flags, which apply to every command.  They are set once the
+// flags are parsed, and each command copies them into its Config with apply.
+type rootOptions struct {
+	flavor   string
+	scheme   *watcher.VersionScheme
+	apiToken string
+}
+
+// apply sets the root command's settings on the config.
+func (r *rootOptions) apply(cfg *watcher.Config) {
+	cfg.Flavor = r.flavor
+	cfg.VersionScheme = r.scheme
+	cfg.APIToken = r.apiToken
 }
 
 func main() {
+	global := &rootOptions{}
 	root := &cobra.Command{}
 	root.AddCommand(
-		newReportCommand(),
-		newBotCommand(),
+		newReportCommand(global),
+		newBotCommand(global),
+		newCheckPayloadCommand(global),
+		newGraphDOTCommand(global),
+		newDoctorCommand(global),
 	)
 
 	original := flag.CommandLine
@@ -75,12 +98,58 @@ func main() {
 	original.Set("v", "2")
 
 	root.PersistentFlags().AddGoFlag(original.Lookup("v"))
-	if err := root.Execute(); err != nil {
+	logFormat := root.PersistentFlags().String("log-format", "text", "Format of log output (text, json)")
+	majorVersion := root.PersistentFlags().Int("major-version", watcher.DefaultMajorVersion, "Major version of the releases to check")
+	flavor := root.PersistentFlags().String("flavor", watcher.FlavorOCP, fmt.Sprintf("Distribution whose release controllers and streams are checked, one of %v", watcher.FlavorNames()))
+	streamTypes := root.PersistentFlags().StringSlice("stream-type", nil, "Type of z-stream to check, e.g. nightly for the MAJOR.N.0-0.nightly streams, may be repeated (default to the flavor's stream types, ci and nightly for ocp)")
+	caFile := root.PersistentFlags().String("ca-file", "", "PEM bundle of CAs to trust in addition to the system CAs, e.g. for a proxy which intercepts TLS.  Proxies are configured with the HTTPS_PROXY and NO_PROXY environment variables.")
+	apiToken := root.PersistentFlags().String("api-token", "", "Bearer token to authenticate to the release API and upgrade graph with, e.g. for a protected staging release controller")
+	apiTokenFile := root.PersistentFlags().String("api-token-file", "", "File to read the --api-token from, so it isn't visible in the process list")
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := setupLogging(original, *logFormat); err != nil {
+			return err
+		}
+		if *caFile != "" {
+			if err := loadCAFile(*caFile); err != nil {
+				return err
+			}
+		}
+		if *apiTokenFile != "" {
+			if *apiToken != "" {
+				return fmt.Errorf("--api-token and --api-token-file are mutually exclusive")
+			}
+			token, err := readTokenFile(*apiTokenFile)
+			if err != nil {
+				return err
+			}
+			*apiToken = token
+		}
+		f, err := watcher.FlavorByName(*flavor)
+		if err != nil {
+			return err
+		}
+		types := *streamTypes
+		if len(types) == 0 {
+			types = f.StreamTypes
+		}
+		scheme, err := watcher.NewVersionScheme(*majorVersion, types)
+		if err != nil {
+			return err
+		}
+		global.flavor = *flavor
+		global.apiToken = *apiToken
+		global.scheme = scheme
+		return nil
+	}
+	// cancel in-flight work when the process is asked to stop
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := root.ExecuteContext(ctx); err != nil {
 		klog.Exitf("error: %v", err)
 	}
 }
 
-func newReportCommand() *cobra.Command {
+func newReportCommand(global *rootOptions) *cobra.Command {
 	o := &options{}
 	cmd := &cobra.Command{
 		Use:   "report",
@@ -89,16 +158,28 @@ func newReportCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return o.runReport()
+			global.apply(&o.Config)
+			if o.printConfig {
+				o.writeConfig(os.Stdout, cmd.Flags())
+				return nil
+			}
+			return o.runReport(cmd.Context())
 		},
 	}
 	flagset := cmd.Flags()
+	flagset.StringVar(&o.format, "format", watcher.FormatText, fmt.Sprintf("Output format, one of %v", watcher.OutputFormats))
+	flagset.StringVar(&o.asOf, "as-of", "", "Report as of this RFC3339 time (e.g. \"2023-06-02T15:00:00Z\") rather than now, ignoring payloads built after it.  Whether payloads had been accepted by then is not known, so later acceptances still count.")
+	flagset.StringVar(&o.webhookURL, "webhook-url", "", "If set, the JSON report is also posted to this url")
+	flagset.StringArrayVar(&o.webhookHeaders, "webhook-header", nil, "A header to send with the --webhook-url post, in the form \"Name: value\".  May be repeated.")
+	flagset.DurationVar(&o.watch, "watch", 0, "Run the report again on this interval until interrupted, printing only what changed since the previous run after the first report, e.g. 10m for a live view in a terminal")
+	flagset.BoolVar(&o.jsonPretty, "json-pretty", false, "Indent the json format for reading, rather than printing it on a single line")
+	flagset.BoolVar(&o.quiet, "quiet", false, "Print nothing when no stream has findings, so only problems are reported")
 	addSharedFlags(flagset, o)
 	return cmd
 }
 
-func newBotCommand() *cobra.Command {
-	o := &options{}
+func newBotCommand(global *rootOptions) *cobra.Command {
+	o := &options{sendMessage: postSlackMessage, uploadSnippet: uploadSlackSnippet}
 	cmd := &cobra.Command{
 		Use:   "bot",
 		Short: "Run the payload report bot server",
@@ -106,36 +187,373 @@ func newBotCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return o.runBot()
+			global.apply(&o.Config)
+			if o.printConfig {
+				o.writeConfig(os.Stdout, cmd.Flags())
+				return nil
+			}
+			return o.runBot(cmd.Context())
 		},
 	}
 
 	flagset := cmd.Flags()
 	flagset.StringVar(&o.slackAlias, "slack-alias", "", "Slack alias to tag in the generated report.  Leave empty to not tag anyone.")
+	flagset.StringVar(&o.schedule, "schedule", "", "Cron expression (minute hour day-of-month month day-of-week, local time) on which to automatically post a report, e.g. \"0 9 * * 1-5\".  Leave empty to only report on request.")
+	flagset.StringSliceVar(&o.reportChannels, "report-channels", nil, "Slack channel IDs to post scheduled reports to, each in its own thread.  Defaults to --default-channel.")
+	flagset.StringVar(&o.defaultChannel, "default-channel", "", "Slack channel ID to post to when a report isn't a reply to a message, e.g. scheduled reports when --report-channels is unset")
+	flagset.StringVar(&o.reportChannel, "report-channel", "", "Slack channel ID to post scheduled reports to")
+	flagset.MarkDeprecated("report-channel", "use --report-channels instead")
+	flagset.BoolVar(&o.asSnippet, "as-snippet", false, "Upload every report to its thread as a text snippet rather than posting it as a message")
+	flagset.IntVar(&o.snippetThreshold, "snippet-threshold", slackMessageLimit, "Upload reports longer than this many characters as a text snippet rather than posting them as a message, which Slack would truncate.  0 only uploads snippets with --as-snippet.")
+	flagset.DurationVar(&o.reportCacheTTL, "report-cache-ttl", 5*time.Minute, "How long a report is reused by the Slack, scheduled and /report.json reports asking for the same report before one generates a new one")
+	flagset.BoolVar(&o.warmup, "warmup", false, "Generate a report at startup, retrying until it succeeds, before /readyz reports the bot as ready.  The report is reused by the Slack, scheduled and /report.json reports asking for the same report until --report-cache-ttl expires.  When unset the bot is ready immediately.")
+	flagset.Int64Var(&o.maxRequestBytes, "max-request-bytes", 1<<20, "The largest request body the bot will accept, larger requests are rejected")
+	flagset.StringVar(&o.tokenFile, "token-file", "", "File to read the Slack token from.  The file is re-read periodically so a rotated token is used without a restart.  Defaults to the TOKEN environment variable when unset.")
 	addSharedFlags(flagset, o)
 	return cmd
 }
 
+func newCheckPayloadCommand(global *rootOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check-payload NAME",
+		Short: "Show how a payload name is parsed, to debug why a payload is or isn't counted",
+		Args:  cobra.ExactArgs(1),
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := &watcher.Config{}
+			global.apply(cfg)
+			return watcher.DescribePayload(os.Stdout, cfg, args[0])
+		},
+	}
+	return cmd
+}
+
+func newGraphDOTCommand(global *rootOptions) *cobra.Command {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:   "graph-dot",
+		Short: "Print the upgrade graph in Graphviz DOT format, e.g. to render with \"dot -Tpng\"",
+
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			global.apply(&o.Config)
+			graph, err := watcher.FetchUpgradeGraph(cmd.Context(), &o.Config)
+			if err != nil {
+				return err
+			}
+			return graph.WriteDOT(os.Stdout, o.Scheme(), o.OldestMinor, o.NewestMinor)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.IntVar(&o.OldestMinor, "oldest-minor", -1, "Only include upgrades to this minor release and newer.  Specify only the minor value (e.g. \"9\") (default to all releases)")
+	flagset.IntVar(&o.NewestMinor, "newest-minor", -1, "Only include upgrades to this minor release and older.  Specify only the minor value (e.g. \"12\") (default to all releases)")
+	flagset.StringVar(&o.Arch, "arch", "amd64", "Which architecture's upgrade graph to print (amd64, arm64)")
+	addGraphFlags(flagset, o)
+	return cmd
+}
+
+// addGraphFlags adds the flags which control how the upgrade graph is fetched.
+func addGraphFlags(flagset *pflag.FlagSet, o *options) {
+	flagset.StringVar(&o.GraphPath, "graph-path", "/graph", "The path of the upgrade graph API on the release controller")
+	flagset.StringVar(&o.GraphChannelParam, "graph-channel-param", "channel", "The query parameter of the upgrade graph API which selects the channel")
+	flagset.StringVar(&o.GraphChannel, "graph-channel", "stable", "The upgrade graph channel which payload upgrades are checked against")
+	flagset.StringVar(&o.GraphAccept, "graph-accept", "", "If set, the Accept header sent with upgrade graph requests, e.g. \"application/vnd.redhat.cincinnati.v1+json\" for a Cincinnati server")
+	flagset.IntVar(&o.MaxConcurrency, "max-concurrency", watcher.DefaultMaxConcurrency, "The most requests to the release API, or stream checks, to run at once.  Lower it if the release API is struggling.")
+	flagset.Int64Var(&o.MaxResponseBytes, "max-response-bytes", watcher.DefaultMaxResponseBytes, "The largest response, after decompression, accepted from the release API.  Larger responses fail the report rather than exhausting memory.")
+}
+
 func addSharedFlags(flagset *pflag.FlagSet, o *options) {
-	flagset.IntVar(&o.oldestMinor, "oldest-minor", -1, "The oldest minor release to analyze.  Release streams older than this will be ignored.  Specify only the minor value (e.g. \"9\") (default to looking up the newest supported release)")
-	flagset.IntVar(&o.newestMinor, "newest-minor", -1, "The newest minor release to analyze.  Release streams newer than this will be ignored.  Specify only the minor value (e.g. \"12\") (default to looking up the newest supported release)")
-	flagset.DurationVar(&o.acceptedStalenessLimit, "accepted-staleness-limit", 24*time.Hour, "How old an accepted payload can be before it is considered stale")
-	flagset.DurationVar(&o.builtStalenessLimit, "built-staleness-limit", 72*time.Hour, "How old an built payload can be before it is considered stale")
-	flagset.DurationVar(&o.upgradeStalenessLimit, "upgrade-staleness-limit", 72*time.Hour, "How old a successful upgrade attempt can be before it's considered stale")
+	flagset.IntVar(&o.OldestMinor, "oldest-minor", -1, "The oldest minor release to analyze.  Release streams older than this will be ignored.  Specify only the minor value (e.g. \"9\") (default to looking up the newest supported release)")
+	flagset.IntVar(&o.NewestMinor, "newest-minor", -1, "The newest minor release to analyze.  Release streams newer than this will be ignored.  Specify only the minor value (e.g. \"12\") (default to looking up the newest supported release)")
+	flagset.DurationVar(&o.AcceptedStalenessLimit, "accepted-staleness-limit", 24*time.Hour, "How old an accepted payload can be before it is considered stale")
+	flagset.DurationVar(&o.BuiltStalenessLimit, "built-staleness-limit", 72*time.Hour, "How old an built payload can be before it is considered stale")
+	flagset.Var(&o.BuiltStalenessByMinor, "built-staleness", "Per minor version overrides of --built-staleness-limit, e.g. \"4.16:24h,4.12:168h\".  Each limit applies to its minor and newer minors up to the next listed minor, older minors use --built-staleness-limit.")
+	flagset.DurationVar(&o.CriticalStalenessLimit, "critical-staleness-limit", 0, "How old a built payload can be before its staleness is critical rather than a warning, e.g. 336h for a stream which hasn't built for two weeks.  0 never escalates it.")
+	flagset.StringVar(&o.TimestampSource, "timestamp-source", watcher.TimestampName, fmt.Sprintf("Time accepted payloads are aged by, one of %v.  name is the build time in the payload's name, accepted is when the payload was accepted, if the release API gives it, falling back to the name.", watcher.TimestampSources))
+	flagset.DurationVar(&o.UpgradeStalenessLimit, "upgrade-staleness-limit", 72*time.Hour, "How old a successful upgrade attempt can be before it's considered stale")
+	flagset.BoolVar(&o.AbsoluteDates, "absolute-dates", false, "Follow the ages of payloads and upgrades in the report with the UTC time they were at, e.g. \"3.2 days ago (2024-06-03 14:22 UTC)\", to correlate them with known incidents")
+	flagset.BoolVar(&o.WithHints, "with-hints", false, "Add the first step to investigate each finding to the report, for on-call engineers unfamiliar with the release process")
+	flagset.StringVar(&o.SortBy, "sort", watcher.SortVersion, fmt.Sprintf("Order to list the streams in, one of %v.  severity lists streams with critical findings first, then those with warnings, each by version.", watcher.SortOrders))
+	flagset.BoolVar(&o.NoUpgradeCheck, "no-upgrade-check", false, "Skip fetching the upgrade graph and checking upgrades, for frequent lightweight checks of only whether the streams are building and accepting payloads")
+	flagset.StringSliceVar(&o.Checks, "checks", nil, fmt.Sprintf("Only run these checks, from %v (default to running all of them)", watcher.CheckNames()))
+	flagset.BoolVar(&o.CheckEUSUpgrades, "check-eus-upgrades", false, "Also check that streams for even (EUS) minor versions have a recent successful upgrade from the previous EUS minor version (n-2)")
+	flagset.IntVar(&o.MaxPayloadsPerStream, "max-payloads-per-stream", 0, "Only check the upgrades to this many of each stream's newest payloads, to speed up reports on streams with hundreds of payloads.  Set too low, upgrades to older payloads which are still within --upgrade-staleness-limit are missed.  0 checks every payload.")
+	flagset.IntVar(&o.MinPayloads, "min-payloads", 0, "Streams with fewer payloads than this in total are reported as having insufficient history instead of being checked for stale or missing accepted payloads, e.g. for a stream whose development just started")
+	flagset.BoolVar(&o.BusinessDaysOnly, "business-days-only", false, "Don't count weekends, or the days given by --holiday, towards the age of payloads and upgrades when comparing them against the staleness limits")
+	flagset.StringVar(&o.CalendarFile, "calendar-file", "", "File listing the dates which don't count towards staleness, e.g. regional holidays, one YYYY-MM-DD date per line, and release freezes as \"freeze START END\" lines.  Stale payloads during a freeze are reported as informational rather than as problems.")
+	flagset.StringArrayVar(&o.Holidays, "holiday", nil, "A date (YYYY-MM-DD) which, like a weekend, doesn't count towards staleness when --business-days-only is set.  May be repeated.")
 	flagset.BoolVar(&o.includeHealthy, "include-healthy", false, "Report about healthy payloads, not just failures")
-	flagset.StringVar(&o.arch, "arch", "amd64", "Which architecture to report on (amd64, arm64)")
+	flagset.StringVar(&o.Arch, "arch", "amd64", "Which architecture to report on (amd64, arm64)")
+	flagset.StringVar(&o.ReleaseAPIUrl, "release-api-url", "", "The url of the release controller to report on, e.g. a staging controller (default to the release controller for --arch)")
+	flagset.StringArrayVar(&o.IncludeStreams, "include-stream", nil, "Only analyze release streams whose name matches this glob pattern (e.g. \"4.*.0-0.nightly\").  May be repeated.")
+	flagset.StringArrayVar(&o.ExcludeStreams, "exclude-stream", nil, "Do not analyze release streams whose name matches this glob pattern.  May be repeated.")
+	flagset.BoolVar(&o.ShowRates, "show-rates", false, "Report how many payloads each stream accepted within --rate-window, and how far apart they were")
+	flagset.DurationVar(&o.RateWindow, "rate-window", 7*24*time.Hour, "The window of time over which --show-rates counts accepted payloads")
+	flagset.DurationVar(&o.Since, "since", 0, "When set, also report how many payloads each stream built and accepted within this long, e.g. 168h for the last week")
+	flagset.BoolVar(&o.ShowHistory, "show-history", false, "Report how many payloads each stream has, and the range of time they were built over")
+	flagset.BoolVar(&o.WithJobLinks, "with-job-links", false, "Link each missing upgrade to the most recent failed job attempting it.  This fetches the release info of each stream's recent payloads, so makes several extra requests per stream missing upgrades.")
+	flagset.BoolVar(&o.ShowPromotionLatency, "show-promotion-latency", false, "Report the median time each stream's payloads took until they, or a newer payload, were accepted, to spot streams which are slow to accept")
+	flagset.BoolVar(&o.GraphSummary, "graph-summary", false, "Report how many edges the upgrade graph has, and how many of them upgrade to each minor version, to spot a minor which can't be upgraded to at all")
+	addGraphFlags(flagset, o)
+	flagset.StringVar(&o.MaintenanceFile, "maintenance-file", "", "File listing the streams under maintenance, e.g. paused for a release freeze, whose findings are not reported as problems.  Each line holds a stream name or glob pattern, optionally followed by the RFC3339 time the maintenance ends.")
+	flagset.StringVar(&o.DumpRawDir, "dump-raw", "", "Directory to write the raw accepted stream, all stream, and upgrade graph responses from the release API to, for debugging")
+	flagset.StringVar(&o.FromDir, "from-dir", "", "Directory of raw responses written by --dump-raw to analyze instead of fetching them from the release API, e.g. to reproduce a report offline")
+	flagset.BoolVar(&o.printConfig, "print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+	flagset.StringVar(&o.stateFile, "state-file", "", "File in which to record the findings of each run, so the next run can report what changed.  Leave empty to not track changes.")
+	flagset.DurationVar(&o.realertInterval, "realert-interval", 0, "When --state-file is set, how long to wait before repeating the findings of an unhealthy stream which have not worsened since they were last reported.  0 always repeats them.")
+	flagset.BoolVar(&o.notifyRecoveries, "notify-recoveries", false, "When --state-file is set, start the report with a RECOVERED section acknowledging the streams which had findings in the previous run but are healthy now")
+	flagset.IntVar(&o.FrozenPayloadRuns, "frozen-payload-runs", 0, "When --state-file is set, report streams whose newest payload has not changed for this many consecutive runs, which can mean the release controller is stalled before the payloads are old enough to be stale.  0 disables the check.")
+	flagset.StringVar(&o.pagerDutyRoutingKey, "pagerduty-routing-key", "", "PagerDuty Events API v2 routing key.  When set, an incident is triggered for each stream with critical findings and resolved once the stream recovers.  Requires --state-file, in which the triggered incidents are recorded.")
+}
+
+// validate checks the options for values which can never produce a useful report.
+func (o *options) validate() error {
+	// -1 means the bound will be looked up from the supported releases when the report is generated.
+	if o.OldestMinor != -1 && o.NewestMinor != -1 {
+		if err := o.Scheme().ValidateMinorRange(o.OldestMinor, o.NewestMinor); err != nil {
+			return err
+		}
+	}
+	if err := watcher.ValidateStreamPatterns(o.IncludeStreams); err != nil {
+		return err
+	}
+	if err := watcher.ValidateStreamPatterns(o.ExcludeStreams); err != nil {
+		return err
+	}
+	if err := watcher.ValidateHolidays(o.Holidays); err != nil {
+		return err
+	}
+	if err := watcher.ValidateMaintenanceFile(o.MaintenanceFile); err != nil {
+		return err
+	}
+	if err := watcher.ValidateCalendarFile(o.CalendarFile); err != nil {
+		return err
+	}
+	if o.FromDir != "" && o.DumpRawDir != "" {
+		return fmt.Errorf("--from-dir and --dump-raw cannot both be set")
+	}
+	if o.CriticalStalenessLimit < 0 {
+		return fmt.Errorf("--critical-staleness-limit must not be negative")
+	}
+	if o.CriticalStalenessLimit > 0 && o.CriticalStalenessLimit < o.BuiltStalenessLimit {
+		return fmt.Errorf("--critical-staleness-limit %s must not be less than --built-staleness-limit %s", o.CriticalStalenessLimit, o.BuiltStalenessLimit)
+	}
+	if o.notifyRecoveries && o.stateFile == "" && o.watch == 0 {
+		return fmt.Errorf("--notify-recoveries requires --state-file, to know which streams had findings in the previous run")
+	}
+	if o.MaxPayloadsPerStream < 0 {
+		return fmt.Errorf("--max-payloads-per-stream must not be negative")
+	}
+	if o.FrozenPayloadRuns < 0 {
+		return fmt.Errorf("--frozen-payload-runs must not be negative")
+	}
+	if o.MaxConcurrency <= 0 {
+		return fmt.Errorf("--max-concurrency must be positive")
+	}
+	if o.MaxResponseBytes <= 0 {
+		return fmt.Errorf("--max-response-bytes must be positive")
+	}
+	if o.asOf != "" {
+		asOf, err := time.Parse(time.RFC3339, o.asOf)
+		if err != nil {
+			return fmt.Errorf("invalid --as-of time %q, must be in RFC3339 format: %v", o.asOf, err)
+		}
+		if asOf.After(time.Now()) {
+			return fmt.Errorf("--as-of time %s is in the future", o.asOf)
+		}
+		o.AsOf = asOf
+	}
+	if o.webhookURL != "" && !isHTTPURL(o.webhookURL) {
+		return fmt.Errorf("invalid --webhook-url %q, must be an http or https url", o.webhookURL)
+	}
+	if o.ReleaseAPIUrl != "" {
+		url, err := watcher.NormalizeReleaseAPIUrl(o.ReleaseAPIUrl)
+		if err != nil {
+			return err
+		}
+		o.ReleaseAPIUrl = url
+	}
+	if err := watcher.ValidateSort(o.SortBy); err != nil {
+		return err
+	}
+	if err := watcher.ValidateTimestampSource(o.TimestampSource); err != nil {
+		return err
+	}
+	if err := watcher.ValidateChecks(o.Checks); err != nil {
+		return err
+	}
+	if o.NoUpgradeCheck {
+		for _, c := range o.Checks {
+			if c == watcher.CheckUpgrades {
+				return fmt.Errorf("--no-upgrade-check and --checks %s cannot both be set", watcher.CheckUpgrades)
+			}
+		}
+		if o.GraphSummary {
+			return fmt.Errorf("--graph-summary requires the upgrade graph, which --no-upgrade-check skips fetching")
+		}
+	}
+	if o.pagerDutyRoutingKey != "" && o.stateFile == "" {
+		return fmt.Errorf("--pagerduty-routing-key requires --state-file, to record which incidents were triggered")
+	}
+	if _, err := parseWebhookHeaders(o.webhookHeaders); err != nil {
+		return err
+	}
+	return nil
 }
 
-func (o *options) runReport() error {
-	report, err := generateReport(o.acceptedStalenessLimit, o.builtStalenessLimit, o.upgradeStalenessLimit, o.oldestMinor, o.newestMinor, o.arch)
+func (o *options) runReport(ctx context.Context) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if err := watcher.ValidateFormat(o.format); err != nil {
+		return err
+	}
+	if o.watch < 0 {
+		return fmt.Errorf("--watch must not be negative")
+	}
+	if o.watch > 0 {
+		return o.watchReport(ctx)
+	}
+	report, err := o.generateReport(ctx)
 	if err != nil {
 		return err
 	}
-	fmt.Println(report.String(o.includeHealthy))
+	return o.printReport(report, false)
+}
+
+// generateReport generates a report, tracks it in the state file and sends its notifications.
+func (o *options) generateReport(ctx context.Context) (*watcher.Report, error) {
+	report, err := watcher.GenerateReport(ctx, &o.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err := report.ApplyState(o.stateFile, o.realertInterval); err != nil {
+		return nil, err
+	}
+	if err := o.notifyPagerDuty(report); err != nil {
+		klog.Errorf("error notifying PagerDuty: %v", err)
+	}
+	if err := o.notifyWebhook(report); err != nil {
+		klog.Errorf("error posting the report to the webhook: %v", err)
+	}
+	return report, nil
+}
+
+// printReport prints the report in the output format, or only its changes since the previous report if
+// changesOnly is set and the format is meant to be read by people.
+func (o *options) printReport(report *watcher.Report, changesOnly bool) error {
+	switch {
+	case o.quiet && report.Unhealthy() == 0:
+		klog.V(2).Infof("All %d streams are healthy, not printing the report\n", len(report.Streams))
+	case changesOnly && report.Diff != nil && (o.format == watcher.FormatText || o.format == watcher.FormatCompact):
+		fmt.Println(report.Diff.String())
+	default:
+		report.JSONPretty = o.jsonPretty
+		output, err := report.Format(o.format, o.includeHealthy)
+		if err != nil {
+			return err
+		}
+		if o.format == watcher.FormatText || o.format == watcher.FormatCompact {
+			output = o.recoveredSection(report) + output
+		}
+		fmt.Println(output)
+	}
+	// always printed, so wrapper scripts can gate on the counts without parsing the report
+	fmt.Fprintln(os.Stderr, report.Summary())
 	return nil
 }
 
-func (o *options) runBot() error {
-	o.serve()
+// recoveredSection returns the report's RECOVERED section, followed by a blank line, if --notify-recoveries is
+// set and any stream recovered since the previous run.
+func (o *options) recoveredSection(report *watcher.Report) string {
+	if !o.notifyRecoveries || report.Diff == nil {
+		return ""
+	}
+	if recovered := report.Diff.RecoveredString(); recovered != "" {
+		return recovered + "\n"
+	}
+	return ""
+}
+
+// watchReport generates a report every watch interval until the context is done, e.g. by Ctrl-C.  The first
+// report is printed in full, and after that only what changed since the previous report, except for the
+// machine readable formats, which are printed in full every time.  A failed report is retried at the next
+// interval.
+func (o *options) watchReport(ctx context.Context) error {
+	var prev *watcher.Report
+	for {
+		report, err := o.generateReport(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			klog.Errorf("error generating the report, retrying in %s: %v", o.watch, err)
+		} else {
+			if prev != nil && report.Diff == nil {
+				report.Compare(prev)
+			}
+			if err := o.printReport(report, prev != nil); err != nil {
+				return err
+			}
+			prev = report
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(o.watch):
+		}
+	}
+}
+
+func (o *options) runBot(ctx context.Context) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.maxRequestBytes <= 0 {
+		return fmt.Errorf("--max-request-bytes must be positive")
+	}
+	if o.snippetThreshold < 0 {
+		return fmt.Errorf("--snippet-threshold must not be negative")
+	}
+	if o.tokenFile != "" {
+		token, err := readTokenFile(o.tokenFile)
+		if err != nil {
+			return err
+		}
+		authToken.Store(token)
+		go watchTokenFile(ctx, o.tokenFile)
+	} else {
+		authToken.Store(os.Getenv("TOKEN"))
+	}
+	if o.reportChannel != "" {
+		o.reportChannels = append(o.reportChannels, o.reportChannel)
+	}
+	for _, channel := range append([]string{o.defaultChannel}, o.reportChannels...) {
+		if channel != "" && !slackChannelRegex.MatchString(channel) {
+			return fmt.Errorf("invalid Slack channel ID %q, expected an ID like C0123ABCD rather than a channel name", channel)
+		}
+	}
+	if o.schedule != "" {
+		if len(o.reportChannels) == 0 && o.defaultChannel != "" {
+			o.reportChannels = []string{o.defaultChannel}
+		}
+		if len(o.reportChannels) == 0 {
+			return fmt.Errorf("--report-channels or --default-channel must be set when --schedule is specified")
+		}
+		s, err := parseSchedule(o.schedule)
+		if err != nil {
+			return err
+		}
+		go o.runSchedule(ctx, s)
+	}
+	if o.warmup {
+		go o.warmUp(ctx)
+	} else {
+		ready.Store(true)
+	}
+	o.serve(ctx)
 	return nil
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+	"k8s.io/klog"
+)
+
+const pagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyEvent is a PagerDuty Events API v2 event.
+type PagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *PagerDutyPayload `json:"payload,omitempty"`
+	Links       []PagerDutyLink   `json:"links,omitempty"`
+}
+
+type PagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
+
+type PagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// notifyPagerDuty triggers an incident for each stream in the report with critical findings, and resolves the
+// incidents it triggered for streams which no longer have any.  The open incidents are recorded in the state file,
+// so streams which were never paged for aren't resolved on every run.
+func (o *options) notifyPagerDuty(rep *watcher.Report) error {
+	if o.pagerDutyRoutingKey == "" {
+		return nil
+	}
+	open, err := watcher.LoadIncidents(o.stateFile)
+	if err != nil {
+		return err
+	}
+
+	incidents := map[string]string{}
+	sent, failed := 0, 0
+	send := func(event PagerDutyEvent, stream string) bool {
+		sent++
+		if err := sendPagerDutyEvent(event); err != nil {
+			klog.Errorf("error sending PagerDuty %s event for stream %s: %v", event.EventAction, stream, err)
+			failed++
+			return false
+		}
+		return true
+	}
+	resolve := func(stream, key string) {
+		if !send(PagerDutyEvent{RoutingKey: o.pagerDutyRoutingKey, EventAction: "resolve", DedupKey: key}, stream) {
+			// the next run tries again
+			incidents[stream] = key
+		}
+	}
+	for stream, r := range rep.Streams {
+		key, isOpen := open[stream]
+		if !r.Critical() {
+			if isOpen {
+				resolve(stream, key)
+			}
+			continue
+		}
+		if r.Maintenance {
+			// leave any open incident as it is, the stream is expected to be broken
+			if isOpen {
+				incidents[stream] = key
+			}
+			continue
+		}
+		if !isOpen {
+			key = pagerDutyDedupKey(rep.ReleaseAPIUrl, o.Arch, stream)
+		}
+		messages := []string{}
+		for _, f := range r.Findings {
+			if f.Severity == watcher.SeverityCritical {
+				messages = append(messages, f.Message)
+			}
+		}
+		event := PagerDutyEvent{
+			RoutingKey:  o.pagerDutyRoutingKey,
+			EventAction: "trigger",
+			// an open incident's key is reused, so repeated triggers update it
+			DedupKey: key,
+			Payload: &PagerDutyPayload{
+				Summary:  fmt.Sprintf("Release stream %s (%s): %s", stream, o.Arch, strings.Join(messages, ", ")),
+				Source:   rep.ReleaseAPIUrl,
+				Severity: "critical",
+			},
+			Links: []PagerDutyLink{{Href: watcher.ReleaseStreamURL(rep.ReleaseAPIUrl, stream), Text: stream}},
+		}
+		if send(event, stream) || isOpen {
+			incidents[stream] = key
+		}
+	}
+	for stream, key := range open {
+		// a stream which is no longer reported on, e.g. an end of life minor, will never recover
+		if _, ok := rep.Streams[stream]; !ok {
+			resolve(stream, key)
+		}
+	}
+
+	if err := watcher.SaveIncidents(o.stateFile, incidents); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to send %d of %d PagerDuty events", failed, sent)
+	}
+	return nil
+}
+
+// pagerDutyDedupKey returns the key of the incident for the stream of the release controller, which includes the
+// controller's host so bots watching different controllers with the same routing key don't share incidents.
+func pagerDutyDedupKey(releaseAPIUrl, arch, stream string) string {
+	host := releaseAPIUrl
+	if u, err := neturl.Parse(releaseAPIUrl); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return fmt.Sprintf("release-watcher/%s/%s/%s", host, arch, stream)
+}
+
+func sendPagerDutyEvent(event PagerDutyEvent) error {
+	eventJson, _ := json.Marshal(event)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	status, body, err := postWithRetry(pagerDutyEventsUrl, pagerDutyEventsUrl, eventJson, header, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusAccepted {
+		return fmt.Errorf("non-Accepted http response code from %s: %d: %s", pagerDutyEventsUrl, status, body)
+	}
+	klog.V(4).Infof("Sent PagerDuty %s event %s\n", event.EventAction, event.DedupKey)
+	return nil
+}
@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"net/http"
+	neturl "net/url"
+)
+
+// apiClient returns the client which makes the requests to the release API and the upgrade graph.  When
+// Config.APIToken is set, it is sent as a bearer token with the requests to the release API's host, and with no
+// others, so it isn't leaked to another host the release API redirects to.
+func (cfg *Config) apiClient() *http.Client {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if cfg.APIToken == "" {
+		return client
+	}
+	releaseAPIUrl, err := cfg.releaseAPIUrl()
+	if err != nil {
+		return client
+	}
+	u, err := neturl.Parse(releaseAPIUrl)
+	if err != nil {
+		return client
+	}
+	withToken := *client
+	withToken.Transport = &bearerTransport{token: cfg.APIToken, host: u.Host, base: client.Transport}
+	return &withToken
+}
+
+// bearerTransport adds an Authorization header to each request to host made with the base transport, or the
+// default transport if base is nil.
+type bearerTransport struct {
+	token string
+	host  string
+	base  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.URL.Host != t.host {
+		return base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return base.RoundTrip(req)
+}
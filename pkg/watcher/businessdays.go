@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+)
+
+// holidayFormat is the format of the dates in Config.Holidays.
+const holidayFormat = "2006-01-02"
+
+// stalenessClock measures how old a payload or upgrade is, for comparison against the staleness limits.
+type stalenessClock struct {
+	// businessDaysOnly excludes weekends and holidays from ages, since fewer payloads are built and
+	// accepted on them.
+	businessDaysOnly bool
+	holidays         map[string]bool
+	// calendar, if set, also excludes its non-working days and release freezes from ages, whether or not
+	// businessDaysOnly is set
+	calendar *calendar
+}
+
+func newStalenessClock(cfg *Config, cal *calendar) *stalenessClock {
+	c := &stalenessClock{businessDaysOnly: cfg.BusinessDaysOnly, holidays: make(map[string]bool), calendar: cal}
+	for _, h := range cfg.Holidays {
+		c.holidays[h] = true
+	}
+	return c
+}
+
+// age returns the time between ts and now which counts towards staleness.
+func (c *stalenessClock) age(ts, now time.Time) time.Duration {
+	if !c.businessDaysOnly && c.calendar == nil {
+		return now.Sub(ts)
+	}
+	var age time.Duration
+	for day := ts; day.Before(now); {
+		next := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location())
+		if next.After(now) {
+			next = now
+		}
+		if c.isWorkingDay(day) {
+			age += next.Sub(day)
+		}
+		day = next
+	}
+	return age
+}
+
+// isWorkingDay returns true if the day counts towards staleness.
+func (c *stalenessClock) isWorkingDay(t time.Time) bool {
+	if c.businessDaysOnly && !c.isBusinessDay(t) {
+		return false
+	}
+	return c.calendar == nil || !c.calendar.excludes(t)
+}
+
+func (c *stalenessClock) isBusinessDay(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.holidays[t.Format(holidayFormat)]
+}
+
+// ValidateHolidays returns an error if any of the holidays isn't a YYYY-MM-DD date.
+func ValidateHolidays(holidays []string) error {
+	for _, h := range holidays {
+		if _, err := time.Parse(holidayFormat, h); err != nil {
+			return fmt.Errorf("invalid holiday %q, expected a YYYY-MM-DD date", h)
+		}
+	}
+	return nil
+}
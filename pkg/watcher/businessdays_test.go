@@ -0,0 +1,175 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPayloadStaleness(t *testing.T) {
+	// built at noon on Friday 2024-05-31
+	stream, payload := "4.15.0-0.nightly", "4.15.0-0.nightly-2024-05-31-120000"
+	built, err := getPayloadTimestamp(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	streams := func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]string{stream: {payload}})
+	}
+	mux.HandleFunc(acceptedReleasePath, streams)
+	mux.HandleFunc(allReleasePath, streams)
+	mux.HandleFunc(defaultGraphPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"nodes": []GraphNode{}, "edges": [][2]int{}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		// age is how long after the payload was built the report is generated
+		age              time.Duration
+		limit            time.Duration
+		businessDaysOnly bool
+		holidays         []string
+		calendar         string
+
+		expectedStale    bool
+		expectedSeverity Severity
+	}{
+		{
+			name:  "fresh just under the limit",
+			age:   24*time.Hour - time.Minute,
+			limit: 24 * time.Hour,
+		},
+		{
+			name:             "stale exactly at the limit",
+			age:              24 * time.Hour,
+			limit:            24 * time.Hour,
+			expectedStale:    true,
+			expectedSeverity: SeverityWarning,
+		},
+		{
+			name:             "weekend counted on the calendar clock",
+			age:              72 * time.Hour,
+			limit:            25 * time.Hour,
+			expectedStale:    true,
+			expectedSeverity: SeverityWarning,
+		},
+		{
+			name:             "weekend excluded on business days",
+			age:              72 * time.Hour,
+			limit:            25 * time.Hour,
+			businessDaysOnly: true,
+		},
+		{
+			name:             "stale exactly at the limit on business days",
+			age:              72 * time.Hour,
+			limit:            24 * time.Hour,
+			businessDaysOnly: true,
+			expectedStale:    true,
+			expectedSeverity: SeverityWarning,
+		},
+		{
+			name:             "holiday counted on business days",
+			age:              96 * time.Hour,
+			limit:            25 * time.Hour,
+			businessDaysOnly: true,
+			expectedStale:    true,
+			expectedSeverity: SeverityWarning,
+		},
+		{
+			name:             "holiday excluded on business days",
+			age:              96 * time.Hour,
+			limit:            25 * time.Hour,
+			businessDaysOnly: true,
+			holidays:         []string{"2024-06-03"},
+		},
+		{
+			name:     "calendar non-working days excluded",
+			age:      96 * time.Hour,
+			limit:    25 * time.Hour,
+			calendar: "# a long weekend\n2024-06-01\n2024-06-02\n2024-06-03\n",
+		},
+		{
+			name:             "stale during a freeze is informational",
+			age:              72 * time.Hour,
+			limit:            24 * time.Hour,
+			calendar:         "freeze 2024-06-03 2024-06-07\n",
+			expectedStale:    true,
+			expectedSeverity: SeverityInfo,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{
+				ReleaseAPIUrl:          srv.URL,
+				OldestMinor:            15,
+				NewestMinor:            15,
+				AcceptedStalenessLimit: tc.limit,
+				BuiltStalenessLimit:    30 * 24 * time.Hour,
+				UpgradeStalenessLimit:  tc.limit,
+				BusinessDaysOnly:       tc.businessDaysOnly,
+				Holidays:               tc.holidays,
+				Clock:                  FakeClock{Time: built.Add(tc.age)},
+			}
+			if tc.calendar != "" {
+				cfg.CalendarFile = filepath.Join(t.TempDir(), "calendar")
+				if err := os.WriteFile(cfg.CalendarFile, []byte(tc.calendar), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rep, err := GenerateReport(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r, ok := rep.Streams[stream]
+			if !ok {
+				t.Fatalf("expected a result for %s, got %v", stream, rep.Streams)
+			}
+			var stale *Finding
+			for i := range r.Findings {
+				if r.Findings[i].Kind == FindingAcceptedStale {
+					stale = &r.Findings[i]
+				}
+			}
+			if (stale != nil) != tc.expectedStale {
+				t.Fatalf("expected stale %t, got findings %+v", tc.expectedStale, r.Findings)
+			}
+			if stale != nil && stale.Severity != tc.expectedSeverity {
+				t.Errorf("expected the stale finding to be %v, got %v", tc.expectedSeverity, stale.Severity)
+			}
+		})
+	}
+}
+
+func TestStalenessClockAge(t *testing.T) {
+	// noon on Friday 2024-05-31 to noon on Tuesday 2024-06-04
+	ts := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	now := ts.Add(96 * time.Hour)
+	tests := []struct {
+		name     string
+		cfg      Config
+		calendar *calendar
+		expected time.Duration
+	}{
+		{name: "calendar time", expected: 96 * time.Hour},
+		{name: "business days", cfg: Config{BusinessDaysOnly: true}, expected: 48 * time.Hour},
+		{name: "business days and a holiday", cfg: Config{BusinessDaysOnly: true, Holidays: []string{"2024-06-03"}}, expected: 24 * time.Hour},
+		{name: "calendar non-working day", calendar: &calendar{nonWorking: map[string]bool{"2024-06-03": true}}, expected: 72 * time.Hour},
+		{name: "calendar freeze", calendar: &calendar{freezes: []freeze{{start: "2024-06-01", end: "2024-06-02"}}}, expected: 48 * time.Hour},
+		{name: "business days and a calendar", cfg: Config{BusinessDaysOnly: true}, calendar: &calendar{nonWorking: map[string]bool{"2024-05-31": true}}, expected: 36 * time.Hour},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if age := newStalenessClock(&tc.cfg, tc.calendar).age(ts, now); age != tc.expected {
+				t.Errorf("expected an age of %s, got %s", tc.expected, age)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// calendar is the working time model loaded from a calendar file: the days which don't count towards staleness,
+// and the release freezes during which stale streams are expected.
+type calendar struct {
+	// nonWorking are the YYYY-MM-DD dates which aren't worked, e.g. regional holidays
+	nonWorking map[string]bool
+	freezes    []freeze
+}
+
+// freeze is a release freeze from the start date to the end date, inclusive, both in YYYY-MM-DD format.
+type freeze struct {
+	start, end string
+}
+
+// loadCalendarFile reads the calendar file, in which each line holds either a non-working YYYY-MM-DD date, or
+// "freeze START END" for a release freeze between the two dates, inclusive.  Blank lines and lines starting with
+// # are ignored.
+func loadCalendarFile(file string) (*calendar, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading calendar file %s: %v", file, err)
+	}
+	defer f.Close()
+
+	cal := &calendar{nonWorking: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		dates := fields
+		if fields[0] == "freeze" {
+			dates = fields[1:]
+			if len(dates) != 2 {
+				return nil, fmt.Errorf("invalid line %d in calendar file %s, expected \"freeze START END\"", n, file)
+			}
+		} else if len(fields) != 1 {
+			return nil, fmt.Errorf("invalid line %d in calendar file %s, expected a date or \"freeze START END\"", n, file)
+		}
+		for _, date := range dates {
+			if _, err := time.Parse(holidayFormat, date); err != nil {
+				return nil, fmt.Errorf("invalid date %q on line %d of calendar file %s, expected a YYYY-MM-DD date", date, n, file)
+			}
+		}
+		if len(dates) == 1 {
+			cal.nonWorking[dates[0]] = true
+			continue
+		}
+		if dates[1] < dates[0] {
+			return nil, fmt.Errorf("invalid freeze on line %d of calendar file %s, it ends on %s before it starts on %s", n, file, dates[1], dates[0])
+		}
+		cal.freezes = append(cal.freezes, freeze{start: dates[0], end: dates[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading calendar file %s: %v", file, err)
+	}
+	return cal, nil
+}
+
+// ValidateCalendarFile returns an error if the calendar file can't be read or parsed.
+func ValidateCalendarFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	_, err := loadCalendarFile(file)
+	return err
+}
+
+// excludes returns true if the day doesn't count towards staleness, because it isn't worked or is in a freeze.
+func (cal *calendar) excludes(day time.Time) bool {
+	return cal.nonWorking[day.Format(holidayFormat)] || cal.freezeAt(day) != nil
+}
+
+// freezeAt returns the release freeze in effect at t, or nil if there is none.  Overlapping freezes return the one
+// which ends last.
+func (cal *calendar) freezeAt(t time.Time) *freeze {
+	date := t.Format(holidayFormat)
+	var found *freeze
+	for i, f := range cal.freezes {
+		if f.start <= date && date <= f.end && (found == nil || f.end > found.end) {
+			found = &cal.freezes[i]
+		}
+	}
+	return found
+}
+
+// applyFreeze downgrades the stale payload findings to informational during a release freeze, when payloads are
+// expected to go stale.
+func (rep *Report) applyFreeze(f *freeze) {
+	rep.freeze = f
+	for _, r := range rep.Streams {
+		for i := range r.Findings {
+			if r.Findings[i].Kind == FindingAcceptedStale || r.Findings[i].Kind == FindingBuiltStale {
+				r.Findings[i].Severity = SeverityInfo
+				r.Findings[i].Message += rep.freezeNote()
+			}
+		}
+	}
+}
+
+// freezeNote is appended to the findings which were downgraded during a release freeze.
+func (rep *Report) freezeNote() string {
+	return fmt.Sprintf(" (expected during the release freeze until %s)", rep.freeze.end)
+}
@@ -0,0 +1,207 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// CheckAccepted checks whether each stream has recently accepted a payload.
+	CheckAccepted = "accepted"
+	// CheckBuilt checks whether each stream has recently built a payload.
+	CheckBuilt = "built"
+	// CheckEmpty checks whether each stream has any accepted and built payloads at all.
+	CheckEmpty = "empty"
+	// CheckUpgrades checks whether each stream's recent payloads have been upgraded to successfully.
+	CheckUpgrades = "upgrades"
+)
+
+// Checks are the built-in analyses, which can be selected with Config.Checks.  CheckNames also lists those added
+// by RegisterCheck.
+var Checks = []string{CheckAccepted, CheckBuilt, CheckEmpty, CheckUpgrades}
+
+// Check is an analysis GenerateReport runs on the streams, unless Config.Checks selects other checks.  The
+// built-in checks are registered as CheckAccepted, CheckBuilt, CheckEmpty and CheckUpgrades, and RegisterCheck
+// and Config.ExtraChecks add more.
+type Check interface {
+	// Name selects the check in Config.Checks.
+	Name() string
+	// Run checks the input's streams, returning the results for those it passed or found problems with, keyed by
+	// stream name.  Only the HealthyMessages and Findings of each result are used, they are added to the stream's
+	// results from the other checks.
+	Run(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error)
+}
+
+// CheckInput is the data fetched for a report, which each Check examines.
+type CheckInput struct {
+	// Config is the configuration the report is generated with.
+	Config *Config
+	// Streams are the names of the streams being checked, in alphabetical order.
+	Streams []string
+	// AcceptedReleases and AllReleases are the accepted and built payloads of each stream, newest first.
+	AcceptedReleases map[string][]string
+	AllReleases      map[string][]string
+	// Graph is the release controller's upgrade graph.
+	Graph GraphMap
+	// Now is the time payload ages are measured from, which is Config.AsOf if it is set.
+	Now time.Time
+
+	releaseAPIUrl string
+	scheme        *VersionScheme
+	filter        *streamFilter
+	staleness     *stalenessClock
+	// acceptedTimes are when the accepted payloads were accepted, if they are aged by it
+	acceptedTimes map[string]time.Time
+	// insufficientHistory are the streams with too few payloads to check for staleness, and how many they have
+	insufficientHistory map[string]int
+}
+
+// checkFunc is a built-in Check.
+type checkFunc struct {
+	name string
+	run  func(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error)
+}
+
+func (c checkFunc) Name() string {
+	return c.name
+}
+
+func (c checkFunc) Run(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error) {
+	return c.run(ctx, in)
+}
+
+var (
+	registeredChecksLock sync.RWMutex
+	// registeredChecks are run in order by every report unless its Config overrides them, the upgrade check first
+	// since its passed checks are listed first.
+	registeredChecks = []Check{
+		checkFunc{name: CheckUpgrades, run: runUpgradesCheck},
+		checkFunc{name: CheckEmpty, run: runEmptyCheck},
+		checkFunc{name: CheckAccepted, run: runAcceptedCheck},
+		checkFunc{name: CheckBuilt, run: runBuiltCheck},
+	}
+)
+
+// RegisterCheck adds the check to those run by default by every report, after the checks already registered.
+// Config.ExtraChecks adds checks to, or overrides the registered checks of, a single Config.
+func RegisterCheck(check Check) error {
+	name := check.Name()
+	if name == "" {
+		return fmt.Errorf("a check must have a name")
+	}
+	registeredChecksLock.Lock()
+	defer registeredChecksLock.Unlock()
+	for _, c := range registeredChecks {
+		if c.Name() == name {
+			return fmt.Errorf("a check named %q is already registered", name)
+		}
+	}
+	registeredChecks = append(registeredChecks, check)
+	return nil
+}
+
+// CheckNames returns the names of the registered checks, the built-in Checks and those added by RegisterCheck, in
+// the order they are run.
+func CheckNames() []string {
+	registeredChecksLock.RLock()
+	defer registeredChecksLock.RUnlock()
+	names := []string{}
+	for _, c := range registeredChecks {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// checks returns the checks the config runs, in order: the registered checks, with any of the same name replaced
+// by the one in Config.ExtraChecks, followed by the rest of the ExtraChecks.
+func (cfg *Config) checks() ([]Check, error) {
+	registeredChecksLock.RLock()
+	checks := append([]Check{}, registeredChecks...)
+	registeredChecksLock.RUnlock()
+
+	extras := map[string]bool{}
+	for _, extra := range cfg.ExtraChecks {
+		name := extra.Name()
+		if name == "" {
+			return nil, fmt.Errorf("a check must have a name")
+		}
+		if extras[name] {
+			return nil, fmt.Errorf("more than one of the extra checks is named %q", name)
+		}
+		extras[name] = true
+		replaced := false
+		for i, c := range checks {
+			if c.Name() == name {
+				checks[i] = extra
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			checks = append(checks, extra)
+		}
+	}
+	return checks, nil
+}
+
+// enabledChecks returns the checks which are run when the upgrade check is skipped, since Config.Checks doesn't
+// say that it was, or nil if every check is run.
+func (cfg *Config) enabledChecks(checks []Check) []string {
+	if !cfg.NoUpgradeCheck {
+		return cfg.Checks
+	}
+	enabled := []string{}
+	for _, c := range checks {
+		if cfg.checkEnabled(c.Name()) {
+			enabled = append(enabled, c.Name())
+		}
+	}
+	return enabled
+}
+
+// streamResult returns the stream's result from results, adding an empty one if it has none yet.
+func streamResult(results map[string]*StreamReport, stream string) *StreamReport {
+	r, ok := results[stream]
+	if !ok {
+		r = &StreamReport{}
+		results[stream] = r
+	}
+	return r
+}
+
+// ValidateChecks returns an error if any of the checks is not one of the registered checks, from CheckNames.
+func ValidateChecks(checks []string) error {
+	names := CheckNames()
+	for _, c := range checks {
+		found := false
+		for _, name := range names {
+			if c == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown check %q, must be one of %v", c, names)
+		}
+	}
+	return nil
+}
+
+// checkEnabled returns true if the check should be run, which all are unless Config.Checks selects some, or
+// Config.NoUpgradeCheck skips the upgrade check.
+func (cfg *Config) checkEnabled(check string) bool {
+	if check == CheckUpgrades && cfg.NoUpgradeCheck {
+		return false
+	}
+	if len(cfg.Checks) == 0 {
+		return true
+	}
+	for _, c := range cfg.Checks {
+		if c == check {
+			return true
+		}
+	}
+	return false
+}
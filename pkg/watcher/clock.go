@@ -0,0 +1,33 @@
+package watcher
+
+import "time"
+
+// Clock tells the current time.  Everything in the report which depends on the time, like whether a payload is
+// stale, is measured against the Config's Clock, so a fixed time can be used to get predictable results.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the system clock.  It is used when no Clock is configured.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock always returns the same time.
+type FakeClock struct {
+	Time time.Time
+}
+
+func (c FakeClock) Now() time.Time {
+	return c.Time
+}
+
+// clockOrReal returns c, or the real clock if c is nil.
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock{}
+	}
+	return c
+}
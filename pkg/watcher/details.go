@@ -0,0 +1,62 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteStreamDetails writes every payload of the stream, newest first, with its age, whether it was accepted and
+// the upgrades to it in the upgrade graph, to investigate a stream in more depth than the report does.
+func WriteStreamDetails(ctx context.Context, w io.Writer, cfg *Config, stream string) error {
+	releaseAPIUrl, err := cfg.releaseAPIUrl()
+	if err != nil {
+		return err
+	}
+	allReleases, _, _, err := cfg.getReleaseStream(ctx, releaseAPIUrl, allReleasePath, allReleasesFile)
+	if err != nil {
+		return err
+	}
+	acceptedReleases, _, _, err := cfg.getReleaseStream(ctx, releaseAPIUrl, acceptedReleasePath, acceptedReleasesFile)
+	if err != nil {
+		return err
+	}
+	graph, err := cfg.fetchUpgradeGraph(ctx, releaseAPIUrl)
+	if err != nil {
+		return err
+	}
+
+	stream = normalizeStreamName(stream)
+	payloads, found := allReleases[stream]
+	if _, ok := acceptedReleases[stream]; !found && !ok {
+		return fmt.Errorf("there is no release stream named %s", stream)
+	}
+	accepted := map[string]bool{}
+	for _, payload := range acceptedReleases[stream] {
+		accepted[payload] = true
+	}
+	payloads = mergePayloads(payloads, acceptedReleases[stream])
+	sortPayloads(map[string][]string{stream: payloads})
+
+	now := clockOrReal(cfg.Clock).Now()
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "%d payloads of %s, newest first:\n", len(payloads), stream)
+	for _, payload := range payloads {
+		line := fmt.Sprintf("  * %s", payload)
+		if ts, err := getPayloadTimestamp(payload); err == nil {
+			line += fmt.Sprintf(", built %.1f days ago", now.Sub(ts).Hours()/24)
+		}
+		if accepted[payload] {
+			line += ", accepted"
+		} else {
+			line += ", not accepted"
+		}
+		fmt.Fprintln(out, line)
+		if froms := graph[payload]; len(froms) > 0 {
+			fmt.Fprintf(out, "    upgrades from %s\n", strings.Join(froms, ", "))
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// colors of the edges in the DOT graph, by the kind of upgrade
+const (
+	dotPatchColor     = "blue"
+	dotMinorColor     = "darkgreen"
+	dotDowngradeColor = "red"
+	// upgrades which skip minor versions, like EUS (n-2) upgrades, and edges whose versions can't be parsed
+	dotOtherColor = "gray"
+)
+
+// WriteDOT writes the upgrade graph in Graphviz DOT format, with a node for each version and an edge for each
+// upgrade, colored by whether it is a patch, minor, or other upgrade.  Only upgrades to versions between
+// oldestMinor and newestMinor (inclusive) of the scheme's major version are written, -1 leaves that end of the
+// range unbounded.
+func (g GraphMap) WriteDOT(w io.Writer, scheme *VersionScheme, oldestMinor, newestMinor int) error {
+	inRange := func(minor int) bool {
+		return (oldestMinor == -1 || minor >= oldestMinor) && (newestMinor == -1 || minor <= newestMinor)
+	}
+	tos := make([]string, 0, len(g))
+	for to := range g {
+		if inRange(scheme.versionMinor(to)) {
+			tos = append(tos, to)
+		}
+	}
+	sort.Strings(tos)
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "// upgrade edges are colored %s for patch, %s for minor, %s for downgrades and %s for any other upgrades\n", dotPatchColor, dotMinorColor, dotDowngradeColor, dotOtherColor)
+	fmt.Fprintln(out, "digraph upgrades {")
+	fmt.Fprintln(out, "  rankdir=LR;")
+	for _, to := range tos {
+		froms := append([]string{}, g[to]...)
+		sort.Strings(froms)
+		toMinor := scheme.versionMinor(to)
+		for _, from := range froms {
+			fmt.Fprintf(out, "  %q -> %q [color=%s];\n", from, to, dotEdgeColor(scheme.versionMinor(from), toMinor))
+		}
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+func dotEdgeColor(fromMinor, toMinor int) string {
+	switch {
+	case fromMinor == -1 || toMinor == -1:
+		return dotOtherColor
+	case toMinor == fromMinor:
+		return dotPatchColor
+	case toMinor == fromMinor+1:
+		return dotMinorColor
+	case toMinor < fromMinor:
+		return dotDowngradeColor
+	default:
+		return dotOtherColor
+	}
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// errorBodySnippetBytes bounds how much of the body of a non-OK response is included in the error, which often
+// says why the request failed, e.g. a maintenance notice or rate limit message.
+const errorBodySnippetBytes = 512
+
+// FetchErrorKind classifies why fetching data from an upstream API failed.
+type FetchErrorKind string
+
+const (
+	// FetchErrorNetwork means the request could not be made or the response could not be read.
+	FetchErrorNetwork FetchErrorKind = "network"
+	// FetchErrorStatus means the API responded with a non-OK http status.
+	FetchErrorStatus FetchErrorKind = "status"
+	// FetchErrorDecode means the API responded, but the response was not in the expected format.
+	FetchErrorDecode FetchErrorKind = "decode"
+)
+
+// FetchError is returned when fetching data from the release or life-cycle APIs fails.
+type FetchError struct {
+	Kind FetchErrorKind
+	// What describes the data being fetched, e.g. "releases"
+	What string
+	URL  string
+	// StatusCode is the http status of the response, for FetchErrorStatus errors
+	StatusCode int
+	// Body is the start of the body of the response, for FetchErrorStatus errors
+	Body string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	switch e.Kind {
+	case FetchErrorStatus:
+		if e.Body != "" {
+			return fmt.Sprintf("non-OK http response code fetching %s from %s: %d: %s", e.What, e.URL, e.StatusCode, e.Body)
+		}
+		return fmt.Sprintf("non-OK http response code fetching %s from %s: %d", e.What, e.URL, e.StatusCode)
+	case FetchErrorDecode:
+		return fmt.Sprintf("error decoding %s from %s: %v", e.What, e.URL, e.Err)
+	default:
+		return fmt.Sprintf("error fetching %s from %s: %v", e.What, e.URL, e.Err)
+	}
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Transient returns true if the failure is likely to go away on its own, e.g. the API is unreachable or
+// overloaded, as opposed to the API returning data we don't understand.
+func (e *FetchError) Transient() bool {
+	switch e.Kind {
+	case FetchErrorNetwork:
+		return true
+	case FetchErrorStatus:
+		return e.StatusCode == 429 || e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// statusError returns the FetchErrorStatus error for the non-OK response res, including the start of its body with
+// the whitespace collapsed, so e.g. a multi-line html error page fits on one line.
+func statusError(res *http.Response, what, url string) *FetchError {
+	e := &FetchError{Kind: FetchErrorStatus, What: what, URL: url, StatusCode: res.StatusCode}
+	var reader io.Reader = res.Body
+	if body, err := newBodyReader(res, what, url, errorBodySnippetBytes); err == nil {
+		defer body.Close()
+		reader = body
+	}
+	// the body is only a hint, so whatever could be read before an error is used
+	snippet, _ := ioutil.ReadAll(io.LimitReader(reader, errorBodySnippetBytes))
+	e.Body = strings.Join(strings.Fields(string(snippet)), " ")
+	if len(snippet) == errorBodySnippetBytes {
+		e.Body += "..."
+	}
+	return e
+}
@@ -0,0 +1,68 @@
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	"k8s.io/klog"
+)
+
+// streamFilter selects which release streams are analyzed by a report.
+type streamFilter struct {
+	oldestMinor int
+	newestMinor int
+	// include and exclude are glob patterns matched against the stream name after the minor version
+	// bounds are applied.  When include is non-empty only matching streams are analyzed.
+	include []string
+	exclude []string
+	// scheme selects the z-streams which can be analyzed at all
+	scheme *VersionScheme
+}
+
+// matches returns true if the stream should be analyzed.
+func (f *streamFilter) matches(stream string) bool {
+	matches := f.scheme.zRelease.FindStringSubmatch(stream)
+	if matches == nil {
+		klog.V(4).Infof("ignoring non z-stream release %s\n", stream)
+		return false
+	}
+	v, _ := strconv.Atoi(matches[1])
+	if v < f.oldestMinor {
+		klog.V(4).Infof("ignoring release %s because it is older than the oldest desired minor %d\n", stream, f.oldestMinor)
+		return false
+	}
+	if v > f.newestMinor {
+		klog.V(4).Infof("ignoring release %s because it is newer than the newest desired minor %d\n", stream, f.newestMinor)
+		return false
+	}
+	if len(f.include) > 0 && !matchesAny(f.include, stream) {
+		klog.V(4).Infof("ignoring release %s because it does not match any included stream pattern\n", stream)
+		return false
+	}
+	if matchesAny(f.exclude, stream) {
+		klog.V(4).Infof("ignoring release %s because it matches an excluded stream pattern\n", stream)
+		return false
+	}
+	return true
+}
+
+func matchesAny(patterns []string, stream string) bool {
+	for _, pattern := range patterns {
+		// patterns are validated up front, so errors can't happen here
+		if ok, _ := path.Match(pattern, stream); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateStreamPatterns returns an error if any of the patterns are not valid globs.
+func ValidateStreamPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid stream pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Severity is how serious a finding is.
+type Severity string
+
+const (
+	// SeverityWarning findings mean the stream is behind, e.g. it hasn't accepted or built a payload recently
+	SeverityWarning Severity = "warning"
+	// SeverityCritical findings mean the stream is completely failing, e.g. it has no accepted or built payloads at all
+	SeverityCritical Severity = "critical"
+	// SeverityInfo findings are expected, so don't make the stream unhealthy, e.g. a stale stream during a release
+	// freeze
+	SeverityInfo Severity = "info"
+)
+
+// FindingKind identifies which check produced a finding.
+type FindingKind string
+
+const (
+	FindingNoAccepted     FindingKind = "no-accepted"
+	FindingAcceptedStale  FindingKind = "accepted-stale"
+	FindingNoBuilt        FindingKind = "no-built"
+	FindingBuiltStale     FindingKind = "built-stale"
+	FindingNoPatchUpgrade FindingKind = "no-patch-upgrade"
+	FindingNoMinorUpgrade FindingKind = "no-minor-upgrade"
+	FindingNoEUSUpgrade   FindingKind = "no-eus-upgrade"
+	FindingDowngradeEdge  FindingKind = "downgrade-edge"
+	// FindingDataAnomaly means the release API data is inconsistent, e.g. a payload was accepted without being built
+	FindingDataAnomaly FindingKind = "data-anomaly"
+	// FindingFrozenPayloads means the stream's newest payload hasn't changed for several runs, see Config.FrozenPayloadRuns
+	FindingFrozenPayloads FindingKind = "frozen-payloads"
+)
+
+// findingOrder is the order a stream's findings are listed in, the upgrade findings first, then the findings
+// about its payloads.  The findings of checks added by RegisterCheck are listed last.
+var findingOrder = []FindingKind{
+	FindingNoPatchUpgrade,
+	FindingNoMinorUpgrade,
+	FindingNoEUSUpgrade,
+	FindingDowngradeEdge,
+	FindingNoAccepted,
+	FindingAcceptedStale,
+	FindingNoBuilt,
+	FindingBuiltStale,
+	FindingDataAnomaly,
+	FindingFrozenPayloads,
+}
+
+// findingLabels are short descriptions of each kind of finding, for the compact output format.
+var findingLabels = map[FindingKind]string{
+	FindingNoAccepted:     "no accepted payloads",
+	FindingAcceptedStale:  "accepted stale",
+	FindingNoBuilt:        "no built payloads",
+	FindingBuiltStale:     "built stale",
+	FindingNoPatchUpgrade: "no patch upgrade",
+	FindingNoMinorUpgrade: "no minor upgrade",
+	FindingNoEUSUpgrade:   "no EUS upgrade",
+	FindingDowngradeEdge:  "downgrade edges",
+	FindingDataAnomaly:    "data anomaly",
+	FindingFrozenPayloads: "frozen payloads",
+}
+
+// findingHints are the first step to investigate each kind of finding, for engineers unfamiliar with the release
+// process.
+var findingHints = map[FindingKind]string{
+	FindingNoAccepted:     "check the release controller's blocking jobs for the stream's payloads, one of them is failing on every payload",
+	FindingAcceptedStale:  "check which blocking jobs failed on the payloads built since the stream last accepted one",
+	FindingNoBuilt:        "check that the stream is still configured on the release controller and that its payload builds are running",
+	FindingBuiltStale:     "check whether the stream's payload builds are failing, or whether its code is frozen and nothing has merged",
+	FindingNoPatchUpgrade: "check the stream's upgrade jobs from the same minor version for failures, or whether any have run recently",
+	FindingNoMinorUpgrade: "check the stream's upgrade jobs from the previous minor version for failures, or whether any have run recently",
+	FindingNoEUSUpgrade:   "check the stream's EUS (n-2) upgrade jobs for failures, or whether any have run recently",
+	FindingDowngradeEdge:  "check the versions of the payloads on the edges, the upgrade graph should only contain upgrades",
+	FindingDataAnomaly:    "compare the stream's payloads on its release controller page, the upstream data or our parsing of it is wrong",
+	FindingFrozenPayloads: "check whether the release controller is still running and creating payloads, especially if other streams are frozen too",
+}
+
+// StreamStatus is the overall health of a stream.
+type StreamStatus string
+
+const (
+	StatusHealthy  StreamStatus = "healthy"
+	StatusWarning  StreamStatus = "warning"
+	StatusCritical StreamStatus = "critical"
+	// StatusMaintenance streams have findings, but are under maintenance so they are expected
+	StatusMaintenance StreamStatus = "maintenance"
+)
+
+// Finding is a problem detected with a stream.
+type Finding struct {
+	Kind     FindingKind
+	Severity Severity
+	// Age is the age of the payload the finding is about, if any
+	Age     time.Duration
+	Message string
+	// StaleRuns is how many consecutive runs, including this one, a staleness finding has been seen for, when
+	// the report's state is tracked.
+	StaleRuns int
+	// JobURL, if set, links to the most recent failed job attempting what the finding is missing, e.g. an upgrade
+	JobURL string
+}
+
+// Hint returns the first step to investigate the finding.
+func (f Finding) Hint() string {
+	return findingHints[f.Kind]
+}
+
+// short returns a brief description of the finding.  Findings of kinds added by RegisterCheck are described by
+// their kind.
+func (f Finding) short() string {
+	label, ok := findingLabels[f.Kind]
+	if !ok {
+		label = string(f.Kind)
+	}
+	if f.Age > 0 {
+		return fmt.Sprintf("%s (%.1fd)", label, f.Age.Hours()/24)
+	}
+	return label
+}
+
+func (r *StreamReport) addFinding(kind FindingKind, severity Severity, age time.Duration, message string) {
+	r.Findings = append(r.Findings, Finding{Kind: kind, Severity: severity, Age: age, Message: message})
+}
+
+// sortFindings orders the stream's findings by findingOrder, so they are listed the same way however they were
+// found.
+func (r *StreamReport) sortFindings() {
+	rank := make(map[FindingKind]int, len(findingOrder))
+	for i, kind := range findingOrder {
+		rank[kind] = i
+	}
+	rankOf := func(kind FindingKind) int {
+		if i, ok := rank[kind]; ok {
+			return i
+		}
+		return len(findingOrder)
+	}
+	sort.SliceStable(r.Findings, func(i, j int) bool { return rankOf(r.Findings[i].Kind) < rankOf(r.Findings[j].Kind) })
+}
+
+// Critical returns true if any of the stream's findings are critical.
+func (r *StreamReport) Critical() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityCritical {
+			return true
+		}
+	}
+	return false
+}
+
+// Unhealthy returns true if any of the stream's findings are more than informational.
+func (r *StreamReport) Unhealthy() bool {
+	for _, f := range r.Findings {
+		if f.Severity != SeverityInfo {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the stream's overall health.  Streams whose findings are only informational are healthy.
+func (r *StreamReport) Status() StreamStatus {
+	switch {
+	case !r.Unhealthy():
+		return StatusHealthy
+	case r.Maintenance:
+		return StatusMaintenance
+	case r.Critical():
+		return StatusCritical
+	}
+	return StatusWarning
+}
+
+// Unhealthy returns the number of unhealthy streams, not counting streams under maintenance.
+func (rep *Report) Unhealthy() int {
+	unhealthy := 0
+	for _, r := range rep.Streams {
+		if r.Unhealthy() && !r.Maintenance {
+			unhealthy++
+		}
+	}
+	return unhealthy
+}
+
+// Summary returns a single machine parseable line counting the streams, and the unhealthy streams by their worst
+// severity and those with no built payloads, e.g. "SUMMARY streams=42 critical=2 warning=5 empty=1".  Streams
+// under maintenance aren't counted as unhealthy.
+func (rep *Report) Summary() string {
+	critical, warning, empty := 0, 0, 0
+	for _, r := range rep.Streams {
+		if r.Maintenance {
+			continue
+		}
+		switch r.severityRank() {
+		case 2:
+			critical++
+		case 1:
+			warning++
+		}
+		for _, f := range r.Findings {
+			if f.Kind == FindingNoBuilt {
+				empty++
+				break
+			}
+		}
+	}
+	return fmt.Sprintf("SUMMARY streams=%d critical=%d warning=%d empty=%d", len(rep.Streams), critical, warning, empty)
+}
+
+// severityRank returns how severe the stream's worst finding is, 2 for critical, 1 for warning and 0 if the stream
+// has no findings, or only informational ones.
+func (r *StreamReport) severityRank() int {
+	switch {
+	case r.Critical():
+		return 2
+	case r.Unhealthy():
+		return 1
+	}
+	return 0
+}
+
+// notAccepting returns true if the stream has no recently accepted payloads.
+func (r *StreamReport) notAccepting() bool {
+	for _, f := range r.Findings {
+		if f.Kind == FindingNoAccepted || f.Kind == FindingAcceptedStale {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// FlavorOCP is OpenShift Container Platform, whose releases are checked by default.
+	FlavorOCP = "ocp"
+	// FlavorOKD is OKD, the community distribution of OpenShift, including its SCOS based releases.
+	FlavorOKD = "okd"
+)
+
+// Flavor is a distribution of OpenShift, which has its own release controllers and names its z-streams
+// differently, e.g. 4.N.0-0.okd rather than 4.N.0-0.nightly.
+type Flavor struct {
+	// ReleaseAPIUrls are the release controllers for each architecture the flavor is built for.
+	ReleaseAPIUrls map[string]string
+	// StreamTypes are the types of z-stream the flavor publishes.
+	StreamTypes []string
+}
+
+// Flavors are the distributions which can be checked by setting Config.Flavor.
+var Flavors = map[string]Flavor{
+	FlavorOCP: {
+		ReleaseAPIUrls: ReleaseAPIUrls,
+		StreamTypes:    DefaultStreamTypes,
+	},
+	FlavorOKD: {
+		ReleaseAPIUrls: map[string]string{
+			"amd64": "https://amd64.origin.releases.ci.openshift.org",
+		},
+		StreamTypes: []string{"okd", "okd-scos"},
+	},
+}
+
+// FlavorNames returns the names of the Flavors in alphabetical order.
+func FlavorNames() []string {
+	names := []string{}
+	for name := range Flavors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FlavorByName returns the named flavor from Flavors.  An empty name is FlavorOCP.
+func FlavorByName(name string) (Flavor, error) {
+	if name == "" {
+		name = FlavorOCP
+	}
+	flavor, ok := Flavors[name]
+	if !ok {
+		return Flavor{}, fmt.Errorf("unknown flavor %q, must be one of %v", name, FlavorNames())
+	}
+	return flavor, nil
+}
@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"fmt"
+)
+
+// graphSummary describes the upgrade graph as a whole, to spot problems which the per-stream checks miss, like
+// no payload of a minor version being reachable by an upgrade.
+type graphSummary struct {
+	edges int
+	// pairs is the number of distinct (from, to) version pairs, which is less than edges if the graph
+	// repeats edges
+	pairs int
+	// inbound is the upgrade edges into the payloads of each minor version in the reported range
+	inbound map[int]inboundEdges
+}
+
+type inboundEdges struct {
+	edges int
+	// fromOlderMinor counts the edges which upgrade from an older minor version
+	fromOlderMinor int
+}
+
+func getGraphSummary(graph GraphMap, scheme *VersionScheme, oldestMinor, newestMinor int) *graphSummary {
+	summary := &graphSummary{inbound: make(map[int]inboundEdges)}
+	for minor := oldestMinor; minor <= newestMinor; minor++ {
+		summary.inbound[minor] = inboundEdges{}
+	}
+	for to, froms := range graph {
+		seen := make(map[string]bool)
+		for _, from := range froms {
+			summary.edges++
+			if !seen[from] {
+				seen[from] = true
+				summary.pairs++
+			}
+		}
+		toMinor := scheme.versionMinor(to)
+		in, ok := summary.inbound[toMinor]
+		if !ok {
+			continue
+		}
+		for _, from := range froms {
+			in.edges++
+			if fromMinor := scheme.versionMinor(from); fromMinor != -1 && fromMinor < toMinor {
+				in.fromOlderMinor++
+			}
+		}
+		summary.inbound[toMinor] = in
+	}
+	return summary
+}
+
+func (rep *Report) graphSummaryString() string {
+	s := rep.graphSummary
+	output := "Upgrade graph:\n"
+	output += fmt.Sprintf("  * %d edges between %d distinct version pairs\n", s.edges, s.pairs)
+	for minor := rep.NewestMinor; minor >= rep.OldestMinor; minor-- {
+		in := s.inbound[minor]
+		if in.edges == 0 {
+			output += fmt.Sprintf("  * %s: no inbound upgrade edges, its payloads can't be upgraded to\n", rep.scheme.FormatMinor(minor))
+			continue
+		}
+		output += fmt.Sprintf("  * %s: %d inbound upgrade edges, %d from older minor versions\n", rep.scheme.FormatMinor(minor), in.edges, in.fromOlderMinor)
+	}
+	return output
+}
@@ -0,0 +1,206 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// releaseInfoPath is the release controller API describing a payload, including the upgrades attempted to it,
+	// which is followed by the stream and the payload
+	releaseInfoPath = "/api/v1/releasestream"
+
+	// jobLinkPayloads bounds how many of a stream's recent payloads are fetched looking for failed upgrade jobs
+	jobLinkPayloads = 5
+
+	// upgradeStateFailed is the state of a failed upgrade attempt in the release info
+	upgradeStateFailed = "Failed"
+)
+
+// releaseInfo is the subset of the release controller's description of a payload used to find upgrade jobs.
+type releaseInfo struct {
+	// UpgradesTo are the upgrades attempted to the payload, grouped by the payload upgraded from
+	UpgradesTo []upgradeHistory `json:"upgradesTo"`
+}
+
+type upgradeHistory struct {
+	From string `json:"fromTag"`
+	To   string `json:"toTag"`
+	// History maps the url of each upgrade job to its result
+	History map[string]upgradeResult `json:"history"`
+}
+
+type upgradeResult struct {
+	State string `json:"state"`
+	URL   string `json:"url"`
+}
+
+// upgradeFindingKind returns the kind of finding reported when no upgrade from fromMinor to toMinor succeeds,
+// or "" if upgrades between them aren't checked.
+func upgradeFindingKind(fromMinor, toMinor int) FindingKind {
+	switch {
+	case toMinor == fromMinor:
+		return FindingNoPatchUpgrade
+	case toMinor == fromMinor+1:
+		return FindingNoMinorUpgrade
+	case toMinor == fromMinor+2 && toMinor%2 == 0:
+		return FindingNoEUSUpgrade
+	}
+	return ""
+}
+
+// addUpgradeJobLinks links each missing upgrade finding to the most recent failed job attempting that kind of
+// upgrade, looking through the stream's newest payloads which are recent enough to count.  Payloads whose
+// release info can't be fetched are skipped, since the links are only a convenience.
+func addUpgradeJobLinks(ctx context.Context, client *http.Client, releaseAPIUrl string, rep *Report, releases map[string][]string, stalenessThreshold time.Duration, clock *stalenessClock, now time.Time, maxBytes int64, workers int) {
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each stream is only handled by one worker, so its report can be updated without locking
+			for stream := range work {
+				addStreamUpgradeJobLinks(ctx, client, releaseAPIUrl, rep.scheme, stream, rep.Streams[stream], releases[stream], stalenessThreshold, clock, now, maxBytes)
+			}
+		}()
+	}
+	for stream, r := range rep.Streams {
+		if ctx.Err() != nil {
+			break
+		}
+		if len(missingUpgradeKinds(r)) > 0 {
+			work <- stream
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+// missingUpgradeKinds returns the kinds of the stream's missing upgrade findings which have no job link yet.
+func missingUpgradeKinds(r *StreamReport) map[FindingKind]struct{} {
+	kinds := map[FindingKind]struct{}{}
+	for _, f := range r.Findings {
+		switch f.Kind {
+		case FindingNoPatchUpgrade, FindingNoMinorUpgrade, FindingNoEUSUpgrade:
+			if f.JobURL == "" {
+				kinds[f.Kind] = struct{}{}
+			}
+		}
+	}
+	return kinds
+}
+
+func addStreamUpgradeJobLinks(ctx context.Context, client *http.Client, releaseAPIUrl string, scheme *VersionScheme, stream string, r *StreamReport, payloads []string, stalenessThreshold time.Duration, clock *stalenessClock, now time.Time, maxBytes int64) {
+	type recent struct {
+		payload string
+		ts      time.Time
+	}
+	candidates := []recent{}
+	for _, payload := range payloads {
+		ts, err := getPayloadTimestamp(payload)
+		if err != nil || clock.age(ts, now).Minutes() > stalenessThreshold.Minutes() {
+			continue
+		}
+		candidates = append(candidates, recent{payload: payload, ts: ts})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ts.After(candidates[j].ts) })
+	if len(candidates) > jobLinkPayloads {
+		candidates = candidates[:jobLinkPayloads]
+	}
+
+	for _, c := range candidates {
+		missing := missingUpgradeKinds(r)
+		if len(missing) == 0 || ctx.Err() != nil {
+			return
+		}
+		toMinor := scheme.versionMinor(c.payload)
+		if toMinor < 0 {
+			continue
+		}
+		url := apiURL(releaseAPIUrl, releaseInfoPath, neturl.PathEscape(stream), "release", neturl.PathEscape(c.payload))
+		info, err := getReleaseInfo(ctx, client, url, maxBytes)
+		if err != nil {
+			klog.Warningf("Unable to look up the upgrade jobs of %s: %v", c.payload, err)
+			continue
+		}
+
+		links := map[FindingKind]string{}
+		for _, h := range info.UpgradesTo {
+			fromMinor := scheme.versionMinor(h.From)
+			if fromMinor < 0 {
+				continue
+			}
+			kind := upgradeFindingKind(fromMinor, toMinor)
+			if _, ok := missing[kind]; !ok {
+				continue
+			}
+			for key, result := range h.History {
+				if result.State != upgradeStateFailed {
+					continue
+				}
+				jobURL := result.URL
+				if jobURL == "" {
+					jobURL = key
+				}
+				if newerJobURL(jobURL, links[kind]) {
+					links[kind] = jobURL
+				}
+			}
+		}
+		for i := range r.Findings {
+			if link, ok := links[r.Findings[i].Kind]; ok && r.Findings[i].JobURL == "" {
+				r.Findings[i].JobURL = link
+			}
+		}
+	}
+}
+
+// newerJobURL returns true if the job url a is more recent than b.  Prow job urls end in a build ID which
+// increases with each run, urls without one are compared as strings.
+func newerJobURL(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	idA, errA := strconv.ParseUint(path.Base(a), 10, 64)
+	idB, errB := strconv.ParseUint(path.Base(b), 10, 64)
+	if errA == nil && errB == nil {
+		return idA > idB
+	}
+	return a > b
+}
+
+func getReleaseInfo(ctx context.Context, client *http.Client, url string, maxBytes int64) (*releaseInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for %s: %v", url, err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, &FetchError{Kind: FetchErrorNetwork, What: "release info", URL: url, Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, statusError(res, "release info", url)
+	}
+	body, err := readBody(res, "release info", url, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	info := &releaseInfo{}
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, &FetchError{Kind: FetchErrorDecode, What: "release info", URL: url, Err: err}
+	}
+	return info, nil
+}
@@ -1,6 +1,7 @@
-package main
+package watcher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -21,26 +22,30 @@ type productLifeCycle struct {
 
 type productLifeCycleVersion struct {
 	Name string `json:"name"`
-	Type string `json:"type:`
+	Type string `json:"type"`
 }
 
-func getSupportedReleases(url string) (int, int, error) {
-	resp, err := http.Get(url)
+func getSupportedReleases(ctx context.Context, url string, majorVersion int) (int, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, 0, fmt.Errorf("error fetching life-cycle data from %s: %s", url, err)
+		return 0, 0, fmt.Errorf("error creating request for %s: %s", url, err)
 	}
-	if resp.StatusCode != 200 {
-		return 0, 0, fmt.Errorf("non-OK http response code from %s: %d", url, resp.StatusCode)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, 0, &FetchError{Kind: FetchErrorNetwork, What: "life-cycle data", URL: url, Err: err}
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, 0, statusError(resp, "life-cycle data", url)
+	}
 
 	data := productLifeCycleResponse{}
 	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0, 0, fmt.Errorf("error decoding life-cycle data from %s: %s", url, err)
+		return 0, 0, &FetchError{Kind: FetchErrorDecode, What: "life-cycle data", URL: url, Err: err}
 	}
 
 	if len(data.Data) != 1 {
-		return 0, 0, fmt.Errorf("life-cycle data from %s contains %d products, but should only contain 1", url)
+		return 0, 0, fmt.Errorf("life-cycle data from %s contains %d products, but should only contain 1", url, len(data.Data))
 	}
 
 	minSupportedRelease := -1
@@ -54,8 +59,8 @@ func getSupportedReleases(url string) (int, int, error) {
 			klog.V(4).Infof("expected one period in %q for parsing a minor version", version.Name)
 			continue
 		}
-		if entries[0] != "4" {
-			klog.V(4).Infof("expected major version 4 in %q, not %q", version.Name, entries[0])
+		if entries[0] != strconv.Itoa(majorVersion) {
+			klog.V(4).Infof("expected major version %d in %q, not %q", majorVersion, version.Name, entries[0])
 			continue
 		}
 
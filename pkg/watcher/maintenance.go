@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// maintenanceEntry marks the streams matching a glob pattern as under maintenance, e.g. paused for a release
+// freeze, until a time.
+type maintenanceEntry struct {
+	pattern string
+	// until is when the maintenance ends, zero if it doesn't end until the entry is removed
+	until time.Time
+}
+
+// loadMaintenanceFile reads the maintenance file, in which each line holds a stream name or glob pattern,
+// optionally followed by the RFC3339 time the maintenance ends.  Blank lines and lines starting with # are
+// ignored.
+func loadMaintenanceFile(file string) ([]maintenanceEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading maintenance file %s: %v", file, err)
+	}
+	defer f.Close()
+
+	entries := []maintenanceEntry{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid line %d in maintenance file %s, expected a stream and optionally when its maintenance ends", n, file)
+		}
+		entry := maintenanceEntry{pattern: fields[0]}
+		if _, err := path.Match(entry.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid stream pattern %q on line %d of maintenance file %s: %v", entry.pattern, n, file, err)
+		}
+		if len(fields) == 2 {
+			entry.until, err = time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid time %q on line %d of maintenance file %s, must be in RFC3339 format: %v", fields[1], n, file, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading maintenance file %s: %v", file, err)
+	}
+	return entries, nil
+}
+
+// ValidateMaintenanceFile returns an error if the maintenance file can't be read or parsed.
+func ValidateMaintenanceFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	_, err := loadMaintenanceFile(file)
+	return err
+}
+
+// applyMaintenance marks the streams matching an unexpired maintenance entry as under maintenance.  Expired
+// entries are ignored, so alerting resumes once the maintenance ends without editing the file.
+func (rep *Report) applyMaintenance(entries []maintenanceEntry, now time.Time) {
+	active := []maintenanceEntry{}
+	for _, entry := range entries {
+		if !entry.until.IsZero() && !now.Before(entry.until) {
+			klog.V(2).Infof("Ignoring maintenance of %s, which ended at %s\n", entry.pattern, entry.until)
+			continue
+		}
+		active = append(active, entry)
+	}
+	for stream, r := range rep.Streams {
+		for _, entry := range active {
+			if ok, _ := path.Match(entry.pattern, stream); !ok {
+				continue
+			}
+			if !r.Maintenance {
+				r.Maintenance = true
+				r.MaintenanceUntil = entry.until
+				continue
+			}
+			// overlapping entries keep the stream under maintenance until the last of them ends, and an entry
+			// without an end outlasts them all
+			if entry.until.IsZero() || (!r.MaintenanceUntil.IsZero() && entry.until.After(r.MaintenanceUntil)) {
+				r.MaintenanceUntil = entry.until
+			}
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// schemaVersion is the version of every machine readable format written by the watcher (the JSON report
+// and the state file).  It must be incremented whenever a field is removed, renamed or changes meaning;
+// adding a field is not a breaking change.
+const schemaVersion = 1
+
+const (
+	FormatText    = "text"
+	FormatJSON    = "json"
+	FormatCompact = "compact"
+	FormatCSV     = "csv"
+)
+
+var OutputFormats = []string{FormatText, FormatJSON, FormatCompact, FormatCSV}
+
+// csvHeader names the columns of the CSV report.
+var csvHeader = []string{"stream", "minor", "type", "severity", "kind", "finding", "age_days"}
+
+const (
+	// SortVersion lists the streams from the newest minor version to the oldest
+	SortVersion = "version"
+	// SortSeverity lists the streams with critical findings first, then those with warnings, then by version
+	SortSeverity = "severity"
+)
+
+// SortOrders are the orders the streams of a report can be listed in.
+var SortOrders = []string{SortVersion, SortSeverity}
+
+// reportJSON is the structure of the JSON report.
+type reportJSON struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// GeneratedAt is when the report was generated, in RFC3339 format.
+	GeneratedAt string `json:"generatedAt"`
+	// AsOf is the time the report was generated as of, in RFC3339 format, when it isn't the time it was generated.
+	AsOf          string `json:"asOf,omitempty"`
+	ReleaseAPIUrl string `json:"releaseAPIUrl"`
+	// OldestMinor and NewestMinor are the bounds (inclusive) of the 4.N minor versions reported on.
+	OldestMinor int `json:"oldestMinor"`
+	NewestMinor int `json:"newestMinor"`
+	// Streams holds every analyzed stream, healthy or not, newest minor version first.
+	Streams []streamJSON `json:"streams"`
+	// Graph is present when the upgrade graph summary was requested.
+	Graph *graphJSON `json:"graph,omitempty"`
+	// Changes is present when a previous run is known from the state file.
+	Changes *changesJSON `json:"changes,omitempty"`
+}
+
+type streamJSON struct {
+	Name string `json:"name"`
+	// URL is the release controller page for the stream.
+	URL string `json:"url"`
+	// Healthy is true when the stream has no findings, other than informational ones.
+	Healthy bool `json:"healthy"`
+	// Status is the stream's overall health: healthy, warning, critical or maintenance.
+	Status string `json:"status"`
+	// Suppressed is true when the stream's findings were already reported by a recent run.
+	Suppressed bool `json:"suppressed,omitempty"`
+	// Maintenance is true when the stream is listed in the maintenance file, so its findings are expected.
+	// MaintenanceUntil is when the maintenance ends, in RFC3339 format, and omitted if it has no end.
+	Maintenance      bool          `json:"maintenance,omitempty"`
+	MaintenanceUntil *string       `json:"maintenanceUntil,omitempty"`
+	Findings         []findingJSON `json:"findings"`
+	// HealthyChecks describes the checks the stream passed.
+	HealthyChecks []string `json:"healthyChecks"`
+	// LastBuilt and LastAccepted are the build times of the stream's newest built and accepted payloads, in
+	// RFC3339 format, or null if it has none.
+	LastBuilt    *string `json:"lastBuilt"`
+	LastAccepted *string `json:"lastAccepted"`
+	// Acceptance is present when acceptance rates were requested.
+	Acceptance *acceptanceJSON `json:"acceptance,omitempty"`
+	// Throughput is present when --since was specified.
+	Throughput *throughputJSON `json:"throughput,omitempty"`
+	// Promotion is present when promotion latency was requested.
+	Promotion *promotionJSON `json:"promotion,omitempty"`
+	// History is present when the payload history was requested.
+	History *historyJSON `json:"history,omitempty"`
+}
+
+type findingJSON struct {
+	// Kind identifies the check which produced the finding, e.g. "accepted-stale".
+	Kind string `json:"kind"`
+	// Severity is one of "warning", "critical" or "info", which is expected and doesn't make the stream unhealthy.
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// JobURL links to the most recent failed attempt of a missing upgrade, when job links were requested.
+	JobURL string `json:"jobURL,omitempty"`
+	// StaleRuns is how many consecutive runs a staleness finding has been seen for, when a state file is used.
+	StaleRuns int `json:"staleRuns,omitempty"`
+	// Hint is the first step to investigate the finding, when hints were requested.
+	Hint string `json:"hint,omitempty"`
+}
+
+type changesJSON struct {
+	Since          string   `json:"since"`
+	NewlyUnhealthy []string `json:"newlyUnhealthy"`
+	Recovered      []string `json:"recovered"`
+	StillUnhealthy []string `json:"stillUnhealthy"`
+	// StoppedAccepting lists the streams which had recently accepted payloads in the previous run, but no longer do.
+	StoppedAccepting []string `json:"stoppedAccepting"`
+}
+
+type acceptanceJSON struct {
+	WindowHours float64 `json:"windowHours"`
+	Accepted    int     `json:"accepted"`
+	// AverageGapHours is the mean time between accepted payloads, or 0 if fewer than two were accepted.
+	AverageGapHours float64 `json:"averageGapHours"`
+}
+
+type historyJSON struct {
+	Payloads int `json:"payloads"`
+	// OldestPayload and NewestPayload are the build times of the stream's payloads, in RFC3339 format.  They are
+	// omitted if the stream has no payloads.
+	OldestPayload string `json:"oldestPayload,omitempty"`
+	NewestPayload string `json:"newestPayload,omitempty"`
+}
+
+type promotionJSON struct {
+	// MedianLatencyHours is the median time from a payload being built until it or a newer payload was
+	// accepted, or 0 if no payload was.
+	MedianLatencyHours float64 `json:"medianLatencyHours"`
+	Promoted           int     `json:"promoted"`
+	Pending            int     `json:"pending"`
+}
+
+type graphJSON struct {
+	Edges         int `json:"edges"`
+	DistinctPairs int `json:"distinctPairs"`
+	// Minors holds every minor version reported on, newest first.
+	Minors []graphMinorJSON `json:"minors"`
+}
+
+type graphMinorJSON struct {
+	Minor        string `json:"minor"`
+	InboundEdges int    `json:"inboundEdges"`
+	// FromOlderMinor counts the inbound edges which upgrade from an older minor version.
+	FromOlderMinor int `json:"fromOlderMinor"`
+}
+
+type throughputJSON struct {
+	SinceHours float64 `json:"sinceHours"`
+	Built      int     `json:"built"`
+	Accepted   int     `json:"accepted"`
+}
+
+// ValidateFormat returns an error if the format is not one of the supported output formats.
+func ValidateFormat(format string) error {
+	for _, f := range OutputFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, must be one of %v", format, OutputFormats)
+}
+
+// ValidateSort returns an error if the order is not one of the supported SortOrders.
+func ValidateSort(order string) error {
+	for _, s := range SortOrders {
+		if order == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort order %q, must be one of %v", order, SortOrders)
+}
+
+// Format renders the report in the requested output format.
+func (rep *Report) Format(format string, includeHealthy bool) (string, error) {
+	switch format {
+	case FormatText:
+		return rep.String(includeHealthy), nil
+	case FormatJSON:
+		generatedAt := rep.generatedAt
+		if generatedAt.IsZero() {
+			generatedAt = clockOrReal(rep.clock).Now()
+		}
+		var data []byte
+		var err error
+		if rep.JSONPretty {
+			data, err = json.MarshalIndent(rep.toJSON(generatedAt), "", "  ")
+		} else {
+			data, err = json.Marshal(rep.toJSON(generatedAt))
+		}
+		if err != nil {
+			return "", fmt.Errorf("error encoding report: %v", err)
+		}
+		return string(data), nil
+	case FormatCompact:
+		return rep.compactString(includeHealthy), nil
+	case FormatCSV:
+		return rep.csvString(includeHealthy)
+	default:
+		return "", ValidateFormat(format)
+	}
+}
+
+func (rep *Report) toJSON(now time.Time) *reportJSON {
+	out := &reportJSON{
+		SchemaVersion: schemaVersion,
+		GeneratedAt:   now.UTC().Format(time.RFC3339),
+		ReleaseAPIUrl: rep.ReleaseAPIUrl,
+		OldestMinor:   rep.OldestMinor,
+		NewestMinor:   rep.NewestMinor,
+		Streams:       []streamJSON{},
+	}
+	if !rep.AsOf.IsZero() {
+		out.AsOf = rep.AsOf.UTC().Format(time.RFC3339)
+	}
+	for _, stream := range rep.sortedStreams() {
+		r := rep.Streams[stream]
+		s := streamJSON{
+			Name:          stream,
+			URL:           ReleaseStreamURL(rep.ReleaseAPIUrl, stream),
+			Healthy:       !r.Unhealthy(),
+			Status:        string(r.Status()),
+			Suppressed:    r.Suppressed,
+			Maintenance:   r.Maintenance,
+			Findings:      []findingJSON{},
+			HealthyChecks: append([]string{}, r.HealthyMessages...),
+		}
+		for _, f := range r.Findings {
+			finding := findingJSON{Kind: string(f.Kind), Severity: string(f.Severity), Message: f.Message, JobURL: f.JobURL, StaleRuns: f.StaleRuns}
+			if rep.withHints {
+				finding.Hint = f.Hint()
+			}
+			s.Findings = append(s.Findings, finding)
+		}
+		if r.Maintenance {
+			s.MaintenanceUntil = timestampJSON(r.MaintenanceUntil)
+		}
+		if newest, ok := rep.newestPayloads[stream]; ok {
+			s.LastBuilt = timestampJSON(newest.built)
+			s.LastAccepted = timestampJSON(newest.accepted)
+		}
+		if rate, ok := rep.acceptanceRates[stream]; ok {
+			s.Acceptance = &acceptanceJSON{
+				WindowHours:     rep.rateWindow.Hours(),
+				Accepted:        rate.accepted,
+				AverageGapHours: rate.averageGap.Hours(),
+			}
+		}
+		if counts, ok := rep.payloadCounts[stream]; ok {
+			s.Throughput = &throughputJSON{
+				SinceHours: rep.since.Hours(),
+				Built:      counts.built,
+				Accepted:   counts.accepted,
+			}
+		}
+		if latency, ok := rep.promotionLatencies[stream]; ok {
+			s.Promotion = &promotionJSON{MedianLatencyHours: latency.median.Hours(), Promoted: latency.promoted, Pending: latency.pending}
+		}
+		if span, ok := rep.payloadSpans[stream]; ok {
+			s.History = &historyJSON{Payloads: span.payloads}
+			if !span.oldest.IsZero() {
+				s.History.OldestPayload = span.oldest.UTC().Format(time.RFC3339)
+				s.History.NewestPayload = span.newest.UTC().Format(time.RFC3339)
+			}
+		}
+		out.Streams = append(out.Streams, s)
+	}
+	if rep.graphSummary != nil {
+		out.Graph = &graphJSON{Edges: rep.graphSummary.edges, DistinctPairs: rep.graphSummary.pairs, Minors: []graphMinorJSON{}}
+		for minor := rep.NewestMinor; minor >= rep.OldestMinor; minor-- {
+			in := rep.graphSummary.inbound[minor]
+			out.Graph.Minors = append(out.Graph.Minors, graphMinorJSON{Minor: rep.scheme.FormatMinor(minor), InboundEdges: in.edges, FromOlderMinor: in.fromOlderMinor})
+		}
+	}
+	if rep.Diff != nil {
+		out.Changes = &changesJSON{
+			Since:            rep.Diff.since.UTC().Format(time.RFC3339),
+			NewlyUnhealthy:   append([]string{}, rep.Diff.newlyUnhealthy...),
+			Recovered:        append([]string{}, rep.Diff.recovered...),
+			StillUnhealthy:   append([]string{}, rep.Diff.stillUnhealthy...),
+			StoppedAccepting: append([]string{}, rep.Diff.stoppedAccepting...),
+		}
+	}
+	return out
+}
+
+// timestampJSON formats t in RFC3339 format, or returns nil if t is zero.
+func timestampJSON(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	formatted := t.UTC().Format(time.RFC3339)
+	return &formatted
+}
+
+// compactString renders one line per stream with findings: the stream, its worst severity, and a short
+// description of each finding.
+func (rep *Report) compactString(includeHealthy bool) string {
+	output := ""
+	for _, stream := range rep.sortedStreams() {
+		r := rep.Streams[stream]
+		if len(r.Findings) == 0 {
+			if includeHealthy {
+				output += fmt.Sprintf("%s [OK]\n", stream)
+			}
+			continue
+		}
+		if r.Suppressed {
+			continue
+		}
+		if r.Maintenance {
+			until := ""
+			if !r.MaintenanceUntil.IsZero() {
+				until = " until " + r.MaintenanceUntil.UTC().Format(time.RFC3339)
+			}
+			output += fmt.Sprintf("%s [MAINTENANCE]%s\n", stream, until)
+			continue
+		}
+		marker := "[WARNING]"
+		if r.Critical() {
+			marker = "[CRITICAL]"
+		} else if !r.Unhealthy() {
+			marker = "[INFO]"
+		}
+		issues := []string{}
+		for _, f := range r.Findings {
+			issues = append(issues, f.short())
+		}
+		output += fmt.Sprintf("%s %s %s\n", stream, marker, strings.Join(issues, ", "))
+	}
+	if len(output) == 0 {
+		output = "No unhealthy payload streams detected\n"
+	}
+	return output
+}
+
+// csvString renders one row per finding, for loading into a spreadsheet.  Healthy streams have a single row with
+// no finding when includeHealthy is set.  The age is only set for findings about a payload's age.
+func (rep *Report) csvString(includeHealthy bool) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvHeader)
+	for _, stream := range rep.sortedStreams() {
+		r := rep.Streams[stream]
+		minor := ""
+		if m := rep.scheme.StreamMinor(stream); m >= 0 {
+			minor = rep.scheme.FormatMinor(m)
+		}
+		if len(r.Findings) == 0 && includeHealthy {
+			w.Write([]string{stream, minor, rep.scheme.streamType(stream), "", "", "", ""})
+		}
+		for _, f := range r.Findings {
+			age := ""
+			if f.Age > 0 {
+				age = fmt.Sprintf("%.1f", f.Age.Hours()/24)
+			}
+			w.Write([]string{stream, minor, rep.scheme.streamType(stream), string(f.Severity), string(f.Kind), f.Message, age})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", fmt.Errorf("error encoding report: %v", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,268 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// acceptanceRate describes how regularly a stream accepted payloads over a window of time.
+type acceptanceRate struct {
+	accepted int
+	// averageGap is the mean time between consecutive accepted payloads in the window, or 0 if fewer
+	// than two payloads were accepted.
+	averageGap time.Duration
+}
+
+func getAcceptanceRates(acceptedReleases map[string][]string, window time.Duration, filter *streamFilter, now time.Time) map[string]acceptanceRate {
+	rates := make(map[string]acceptanceRate)
+	for stream, payloads := range acceptedReleases {
+		if !filter.matches(stream) {
+			continue
+		}
+		timestamps := []time.Time{}
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			if now.Sub(ts) <= window {
+				timestamps = append(timestamps, ts)
+			}
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i].Before(timestamps[j]) })
+
+		rate := acceptanceRate{accepted: len(timestamps)}
+		if len(timestamps) > 1 {
+			rate.averageGap = timestamps[len(timestamps)-1].Sub(timestamps[0]) / time.Duration(len(timestamps)-1)
+		}
+		rates[stream] = rate
+	}
+	return rates
+}
+
+func (rep *Report) acceptanceRatesString() string {
+	output := fmt.Sprintf("Accepted payloads over the last %.1f days:\n", rep.rateWindow.Hours()/24)
+	for _, stream := range rep.sortedStreams() {
+		rate, ok := rep.acceptanceRates[stream]
+		if !ok {
+			continue
+		}
+		switch rate.accepted {
+		case 0:
+			output += fmt.Sprintf("  * %s: none accepted\n", stream)
+		case 1:
+			output += fmt.Sprintf("  * %s: 1 accepted\n", stream)
+		default:
+			output += fmt.Sprintf("  * %s: %d accepted, on average %.1f days apart\n", stream, rate.accepted, rate.averageGap.Hours()/24)
+		}
+	}
+	return output
+}
+
+// payloadCounts is how many payloads a stream built and accepted within a window of time.
+type payloadCounts struct {
+	built    int
+	accepted int
+}
+
+func getPayloadCounts(acceptedReleases, allReleases map[string][]string, window time.Duration, filter *streamFilter, now time.Time) map[string]payloadCounts {
+	counts := make(map[string]payloadCounts)
+	countRecent := func(payloads []string) int {
+		n := 0
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			if now.Sub(ts) <= window {
+				n++
+			}
+		}
+		return n
+	}
+	for stream, payloads := range allReleases {
+		if !filter.matches(stream) {
+			continue
+		}
+		counts[stream] = payloadCounts{
+			built:    countRecent(payloads),
+			accepted: countRecent(acceptedReleases[stream]),
+		}
+	}
+	return counts
+}
+
+func (rep *Report) payloadCountsString() string {
+	output := fmt.Sprintf("Payloads built and accepted over the last %.1f days:\n", rep.since.Hours()/24)
+	for _, stream := range rep.sortedStreams() {
+		counts, ok := rep.payloadCounts[stream]
+		if !ok {
+			continue
+		}
+		output += fmt.Sprintf("  * %s: %d built, %d accepted\n", stream, counts.built, counts.accepted)
+	}
+	return output
+}
+
+// newestPayloads is when the newest built and accepted payloads of a stream were built.  A zero time means the
+// stream has no such payload.
+type newestPayloads struct {
+	built    time.Time
+	accepted time.Time
+}
+
+func getNewestPayloads(acceptedReleases, allReleases map[string][]string, filter *streamFilter) map[string]newestPayloads {
+	newestOf := func(payloads []string) time.Time {
+		var newest time.Time
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			if ts.After(newest) {
+				newest = ts
+			}
+		}
+		return newest
+	}
+	newest := make(map[string]newestPayloads)
+	for stream, payloads := range allReleases {
+		if !filter.matches(stream) {
+			continue
+		}
+		newest[stream] = newestPayloads{
+			built:    newestOf(payloads),
+			accepted: newestOf(acceptedReleases[stream]),
+		}
+	}
+	return newest
+}
+
+// payloadSpan is the range of time covered by the payloads in a stream.
+type payloadSpan struct {
+	payloads int
+	oldest   time.Time
+	newest   time.Time
+}
+
+func getPayloadSpans(allReleases map[string][]string, filter *streamFilter) map[string]payloadSpan {
+	spans := make(map[string]payloadSpan)
+	for stream, payloads := range allReleases {
+		if !filter.matches(stream) {
+			continue
+		}
+		span := payloadSpan{payloads: len(payloads)}
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			if span.oldest.IsZero() || ts.Before(span.oldest) {
+				span.oldest = ts
+			}
+			if ts.After(span.newest) {
+				span.newest = ts
+			}
+		}
+		spans[stream] = span
+	}
+	return spans
+}
+
+func (rep *Report) payloadSpansString(now time.Time) string {
+	output := "Payload history:\n"
+	for _, stream := range rep.sortedStreams() {
+		span, ok := rep.payloadSpans[stream]
+		if !ok {
+			continue
+		}
+		switch {
+		case span.oldest.IsZero():
+			output += fmt.Sprintf("  * %s: %d payloads\n", stream, span.payloads)
+		case span.payloads == 1:
+			output += fmt.Sprintf("  * %s: 1 payload, %.1f days old\n", stream, now.Sub(span.oldest).Hours()/24)
+		default:
+			output += fmt.Sprintf("  * %s: spans %d payloads over %.1f days, the oldest is %.1f days old\n", stream, span.payloads, span.newest.Sub(span.oldest).Hours()/24, now.Sub(span.oldest).Hours()/24)
+		}
+	}
+	return output
+}
+
+// promotionLatency is how long a stream's payloads waited to be promoted.  The release API doesn't record when
+// a payload was accepted, only when it was built, so a payload counts as promoted once it, or any payload built
+// after it (which includes its changes), is accepted.  Its latency is the time until that accepted payload was
+// built, a lower bound on the time until its changes were actually accepted.
+type promotionLatency struct {
+	// median is the median latency of the promoted payloads
+	median time.Duration
+	// promoted counts the payloads which have been promoted, and pending those which haven't yet
+	promoted int
+	pending  int
+}
+
+func getPromotionLatencies(acceptedReleases, allReleases map[string][]string, filter *streamFilter) map[string]promotionLatency {
+	timestamps := func(payloads []string) []time.Time {
+		ts := []time.Time{}
+		for _, payload := range payloads {
+			t, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			ts = append(ts, t)
+		}
+		sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+		return ts
+	}
+	latencies := make(map[string]promotionLatency)
+	for stream, payloads := range allReleases {
+		if !filter.matches(stream) {
+			continue
+		}
+		accepted := timestamps(acceptedReleases[stream])
+		result := promotionLatency{}
+		waits := []time.Duration{}
+		for _, built := range timestamps(payloads) {
+			// the first payload accepted which was built no earlier than this one
+			i := sort.Search(len(accepted), func(i int) bool { return !accepted[i].Before(built) })
+			if i == len(accepted) {
+				result.pending++
+				continue
+			}
+			waits = append(waits, accepted[i].Sub(built))
+		}
+		result.promoted = len(waits)
+		if len(waits) > 0 {
+			sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+			result.median = waits[len(waits)/2]
+			if len(waits)%2 == 0 {
+				result.median = (waits[len(waits)/2-1] + waits[len(waits)/2]) / 2
+			}
+		}
+		latencies[stream] = result
+	}
+	return latencies
+}
+
+func (rep *Report) promotionLatenciesString() string {
+	output := "Promotion latency (time from a payload being built until it, or a newer payload, was accepted):\n"
+	for _, stream := range rep.sortedStreams() {
+		latency, ok := rep.promotionLatencies[stream]
+		if !ok {
+			continue
+		}
+		if latency.promoted == 0 {
+			output += fmt.Sprintf("  * %s: none of %d payloads promoted\n", stream, latency.pending)
+			continue
+		}
+		output += fmt.Sprintf("  * %s: median %.1f hours over %d promoted payloads, %d not yet promoted\n", stream, latency.median.Hours(), latency.promoted, latency.pending)
+	}
+	return output
+}
@@ -0,0 +1,1669 @@
+package watcher
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// StreamReport is the result of checking a single release stream.
+type StreamReport struct {
+	// HealthyMessages describes the checks the stream passed.
+	HealthyMessages []string
+	// Findings are the problems found with the stream, it is healthy if there are none.
+	Findings []Finding
+
+	// Suppressed is set when the stream's findings were already reported by a recent run and haven't worsened.
+	Suppressed bool
+	// Maintenance is set when the stream is listed in the maintenance file, so its findings are expected and
+	// not reported as problems.  MaintenanceUntil is when the maintenance ends, zero if it has no end.
+	Maintenance      bool
+	MaintenanceUntil time.Time
+}
+
+// Report is the result of checking every release stream for an architecture.
+type Report struct {
+	// Streams holds the result for each stream checked, keyed by stream name.
+	Streams map[string]*StreamReport
+	// OldestMinor and NewestMinor are the bounds (inclusive) of the 4.N minor versions checked.
+	OldestMinor int
+	NewestMinor int
+	// ReleaseAPIUrl is the release controller the streams were read from.
+	ReleaseAPIUrl string
+	// AsOf is the time the report was generated as of, when Config.AsOf was set.
+	AsOf time.Time
+	// now is the time payload ages are relative to
+	now time.Time
+	// generatedAt is when the report was generated, which differs from now when AsOf is set
+	generatedAt time.Time
+	// fromDir is the directory the release API responses were read from, if they weren't fetched
+	fromDir string
+	// the staleness limits the streams were checked against
+	acceptedStalenessLimit, builtStalenessLimit, upgradeStalenessLimit time.Duration
+	builtStalenessByMinor                                              MinorDurations
+	criticalStalenessLimit                                             time.Duration
+	timestampSource                                                    string
+	businessDaysOnly                                                   bool
+	// clock tells the time when the report is rendered and its state recorded
+	clock Clock
+	// scheme is the version scheme of the checked streams
+	scheme *VersionScheme
+
+	includeStreams []string
+	excludeStreams []string
+	// checks are the checks which were run, if not all of them
+	checks []string
+	// sortBy is the order the streams are listed in, see Config.SortBy
+	sortBy string
+	// withHints adds the first investigation step to each finding, see Config.WithHints
+	withHints bool
+	// frozenPayloadRuns is how many runs a stream's newest payload may go unchanged, see Config.FrozenPayloadRuns
+	frozenPayloadRuns int
+	// freeze is the release freeze in effect when the report was generated, if any
+	freeze *freeze
+
+	// Diff is the change since the previous run, if ApplyState found a previous run.
+	Diff *ReportDiff
+	// acceptanceRates is the accepted payload history of each stream, when requested
+	acceptanceRates map[string]acceptanceRate
+	rateWindow      time.Duration
+	// payloadCounts is the number of payloads each stream built and accepted within the since window, when requested
+	payloadCounts map[string]payloadCounts
+	since         time.Duration
+	// payloadSpans is the range of time covered by each stream's payloads, when requested
+	payloadSpans map[string]payloadSpan
+	// graphSummary describes the edges of the whole upgrade graph, when requested
+	graphSummary *graphSummary
+	// promotionLatencies is how long each stream's payloads took to be promoted, when requested
+	promotionLatencies map[string]promotionLatency
+	// newestPayloads is when each stream's newest built and accepted payloads were built
+	newestPayloads map[string]newestPayloads
+
+	// SlackEmoji prefixes each finding and passed check with a Slack emoji indicating its severity when
+	// the report is rendered as text.
+	SlackEmoji bool
+	// JSONPretty indents the JSON format for people to read, rather than rendering it on a single line.
+	JSONPretty bool
+}
+
+// GenerateReport fetches the release streams and upgrade graph for the configured architecture and checks
+// each selected stream.  Errors fetching data from an API are returned as a *FetchError.
+func GenerateReport(ctx context.Context, cfg *Config) (*Report, error) {
+	acceptedStalenessLimit, builtStalenessLimit, upgradeStalenessLimit := cfg.AcceptedStalenessLimit, cfg.BuiltStalenessLimit, cfg.UpgradeStalenessLimit
+	scheme := cfg.Scheme()
+	checks, err := cfg.checks()
+	if err != nil {
+		return nil, err
+	}
+	oldestMinor, newestMinor := cfg.OldestMinor, cfg.NewestMinor
+	if oldestMinor == -1 || newestMinor == -1 {
+		oldestSupportedMinor, newestSupportedMinor, err := getSupportedReleases(ctx, LifeCycleUrl, scheme.Major)
+		if err != nil {
+			return nil, err
+		}
+		if oldestMinor == -1 {
+			oldestMinor = oldestSupportedMinor
+		}
+		if newestMinor == -1 {
+			newestMinor = newestSupportedMinor
+		}
+	}
+	if err := scheme.ValidateMinorRange(oldestMinor, newestMinor); err != nil {
+		return nil, err
+	}
+	filter := &streamFilter{
+		oldestMinor: oldestMinor,
+		newestMinor: newestMinor,
+		include:     cfg.IncludeStreams,
+		exclude:     cfg.ExcludeStreams,
+		scheme:      scheme,
+	}
+
+	releaseAPIUrl, err := cfg.releaseAPIUrl()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DumpRawDir != "" {
+		if err := os.MkdirAll(cfg.DumpRawDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating raw data directory %s: %v", cfg.DumpRawDir, err)
+		}
+	}
+
+	// the accepted stream, all stream, and upgrade graph are independent of each other, so fetch them concurrently,
+	// up to the concurrency limit.  The report needs all of them, so the first to fail cancels the others and is
+	// the error returned.
+	var (
+		acceptedReleases, allReleases map[string][]string
+		acceptedTimes                 map[string]time.Time
+		acceptedMoved, allMoved       string
+		stableGraph                   GraphMap
+		fetchErr                      error
+		fetchErrOnce                  sync.Once
+		wg                            sync.WaitGroup
+	)
+	fetchCtx, cancelFetches := context.WithCancel(ctx)
+	defer cancelFetches()
+	fetchSlots := make(chan struct{}, cfg.maxConcurrency())
+	fetch := func(f func(ctx context.Context) error) {
+		defer wg.Done()
+		select {
+		case fetchSlots <- struct{}{}:
+		case <-fetchCtx.Done():
+			return
+		}
+		defer func() { <-fetchSlots }()
+		if err := f(fetchCtx); err != nil {
+			fetchErrOnce.Do(func() {
+				fetchErr = err
+				cancelFetches()
+			})
+		}
+	}
+	wg.Add(2)
+	go fetch(func(ctx context.Context) (err error) {
+		acceptedReleases, acceptedTimes, acceptedMoved, err = cfg.getReleaseStream(ctx, releaseAPIUrl, acceptedReleasePath, acceptedReleasesFile)
+		return err
+	})
+	go fetch(func(ctx context.Context) (err error) {
+		allReleases, _, allMoved, err = cfg.getReleaseStream(ctx, releaseAPIUrl, allReleasePath, allReleasesFile)
+		return err
+	})
+	if !cfg.NoUpgradeCheck {
+		wg.Add(1)
+		go fetch(func(ctx context.Context) (err error) {
+			// stable graph only includes successful edges.  nightly+prerelease include edges for any upgrade attempt that was
+			// made, regardless of whether the job passed.
+			stableGraph, err = cfg.fetchUpgradeGraph(ctx, releaseAPIUrl)
+			return err
+		})
+	}
+	wg.Wait()
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if err := ctx.Err(); err != nil {
+		// the report was cancelled before every fetch started
+		return nil, err
+	}
+	// if the release controller has moved, link to where it is now, and look up the job links there
+	for _, moved := range []string{acceptedMoved, allMoved} {
+		if moved != "" {
+			klog.Warningf("The release API at %s redirected to %s, so the report links there instead, update the configured release API url to avoid the redirect", releaseAPIUrl, moved)
+			releaseAPIUrl = moved
+			break
+		}
+	}
+
+	clock := clockOrReal(cfg.Clock)
+	now := clock.Now()
+	generatedAt := now
+	if !cfg.AsOf.IsZero() {
+		// payloads built after the report time didn't exist yet
+		now = cfg.AsOf
+		acceptedReleases = payloadsBuiltBy(acceptedReleases, now)
+		allReleases = payloadsBuiltBy(allReleases, now)
+	}
+
+	var cal *calendar
+	if cfg.CalendarFile != "" {
+		// the calendar is read by every report, so edits take effect without a restart
+		if cal, err = loadCalendarFile(cfg.CalendarFile); err != nil {
+			return nil, err
+		}
+	}
+	// the response order isn't meaningful, so don't let it change the report
+	sortPayloads(acceptedReleases)
+	sortPayloads(allReleases)
+	// every analyzed stream should be listed by both the accepted and all streams.  Those missing from one are
+	// treated as having no payloads in it, so they are still checked, and reported below.
+	onlyAccepted, onlyAll := mismatchedStreams(acceptedReleases, allReleases, filter)
+	for _, stream := range onlyAccepted {
+		allReleases[stream] = nil
+	}
+	for _, stream := range onlyAll {
+		acceptedReleases[stream] = nil
+	}
+	staleness := newStalenessClock(cfg, cal)
+	report := &Report{
+		Streams:        make(map[string]*StreamReport, len(allReleases)),
+		OldestMinor:    oldestMinor,
+		NewestMinor:    newestMinor,
+		includeStreams: cfg.IncludeStreams,
+		excludeStreams: cfg.ExcludeStreams,
+	}
+	report.scheme = scheme
+	report.ReleaseAPIUrl = releaseAPIUrl
+	report.AsOf = cfg.AsOf
+	report.now = now
+	report.clock = clock
+	report.checks = cfg.enabledChecks(checks)
+	report.sortBy = cfg.SortBy
+	report.withHints = cfg.WithHints
+	report.frozenPayloadRuns = cfg.FrozenPayloadRuns
+	report.generatedAt = generatedAt
+	report.fromDir = cfg.FromDir
+	report.acceptedStalenessLimit, report.builtStalenessLimit, report.upgradeStalenessLimit = acceptedStalenessLimit, builtStalenessLimit, upgradeStalenessLimit
+	report.builtStalenessByMinor = cfg.BuiltStalenessByMinor
+	report.criticalStalenessLimit = cfg.CriticalStalenessLimit
+	report.timestampSource = cfg.TimestampSource
+	report.businessDaysOnly = cfg.BusinessDaysOnly
+
+	in := &CheckInput{
+		Config:              cfg,
+		AcceptedReleases:    acceptedReleases,
+		AllReleases:         allReleases,
+		Graph:               stableGraph,
+		Now:                 now,
+		releaseAPIUrl:       releaseAPIUrl,
+		scheme:              scheme,
+		filter:              filter,
+		staleness:           staleness,
+		insufficientHistory: getInsufficientHistoryStreams(allReleases, cfg.MinPayloads, filter),
+	}
+	if cfg.TimestampSource == TimestampAccepted {
+		in.acceptedTimes = acceptedTimes
+	}
+	for _, stream := range sortedStreams(allReleases) {
+		if filter.matches(stream) {
+			in.Streams = append(in.Streams, stream)
+			report.Streams[stream] = &StreamReport{}
+		}
+	}
+	for _, check := range checks {
+		if !cfg.checkEnabled(check.Name()) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		results, err := check.Run(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("error running the %s check: %w", check.Name(), err)
+		}
+		for stream, result := range results {
+			r, ok := report.Streams[stream]
+			if !ok {
+				klog.Warningf("Ignoring the %s check's results for stream %s, which isn't being checked", check.Name(), stream)
+				continue
+			}
+			r.HealthyMessages = append(r.HealthyMessages, result.HealthyMessages...)
+			r.Findings = append(r.Findings, result.Findings...)
+		}
+	}
+	for stream, count := range in.insufficientHistory {
+		report.Streams[stream].HealthyMessages = append(report.Streams[stream].HealthyMessages, fmt.Sprintf("Has insufficient history to check for stale payloads, only %d of the required %d payloads have been built", count, cfg.MinPayloads))
+	}
+
+	if cfg.WithJobLinks && cfg.checkEnabled(CheckUpgrades) {
+		addUpgradeJobLinks(ctx, cfg.apiClient(), releaseAPIUrl, report, allReleases, upgradeStalenessLimit, staleness, now, cfg.MaxResponseBytes, cfg.maxConcurrency())
+	}
+
+	notBuilt := map[string]bool{}
+	for _, stream := range onlyAccepted {
+		notBuilt[stream] = true
+		report.Streams[stream].addFinding(FindingDataAnomaly, SeverityWarning, 0, "Is listed by the accepted stream but not by the all stream, so the release API data is inconsistent")
+	}
+	for _, stream := range onlyAll {
+		report.Streams[stream].addFinding(FindingDataAnomaly, SeverityWarning, 0, "Is listed by the all stream but not by the accepted stream, so the release API data is inconsistent")
+	}
+	report.newestPayloads = getNewestPayloads(acceptedReleases, allReleases, filter)
+	for stream, newest := range report.newestPayloads {
+		// a payload can't be accepted before it is built, so this means the release API data, or our reading of it,
+		// is wrong.  A stream missing from the all stream has already been reported.
+		if r, ok := report.Streams[stream]; ok && newest.accepted.After(newest.built) && !notBuilt[stream] {
+			r.addFinding(FindingDataAnomaly, SeverityWarning, 0, fmt.Sprintf("Has an accepted payload built at %s, newer than its newest built payload from %s, so the release API data is inconsistent", newest.accepted.UTC().Format(time.RFC3339), newest.built.UTC().Format(time.RFC3339)))
+		}
+	}
+	if cfg.MaintenanceFile != "" {
+		entries, err := loadMaintenanceFile(cfg.MaintenanceFile)
+		if err != nil {
+			return nil, err
+		}
+		report.applyMaintenance(entries, now)
+	}
+	if cal != nil {
+		if f := cal.freezeAt(now); f != nil {
+			report.applyFreeze(f)
+		}
+	}
+
+	if cfg.ShowRates {
+		report.acceptanceRates = getAcceptanceRates(acceptedReleases, cfg.RateWindow, filter, now)
+		report.rateWindow = cfg.RateWindow
+	}
+	if cfg.Since > 0 {
+		report.payloadCounts = getPayloadCounts(acceptedReleases, allReleases, cfg.Since, filter, now)
+		report.since = cfg.Since
+	}
+	if cfg.ShowHistory {
+		report.payloadSpans = getPayloadSpans(allReleases, filter)
+	}
+	if cfg.ShowPromotionLatency {
+		report.promotionLatencies = getPromotionLatencies(acceptedReleases, allReleases, filter)
+	}
+	if cfg.GraphSummary {
+		report.graphSummary = getGraphSummary(stableGraph, scheme, oldestMinor, newestMinor)
+	}
+	for _, r := range report.Streams {
+		r.sortFindings()
+	}
+
+	return report, nil
+}
+
+// runUpgradesCheck checks whether each stream's recent payloads have been upgraded to successfully.
+func runUpgradesCheck(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error) {
+	cfg := in.Config
+	return checkUpgrades(ctx, in.scheme, in.Graph, in.AllReleases, in.Streams, cfg.UpgradeStalenessLimit, in.staleness, in.Now, cfg.CheckEUSUpgrades, cfg.AbsoluteDates, cfg.MaxPayloadsPerStream, cfg.maxConcurrency())
+}
+
+// runEmptyCheck checks whether each stream has any accepted and built payloads at all.
+func runEmptyCheck(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error) {
+	results := map[string]*StreamReport{}
+	klog.V(4).Info("Checking streams for accepted payloads\n")
+	acceptedEmpty, _ := getEmptyAndStaleStreams(in.AcceptedReleases, nil, &stalenessLimit{defaultLimit: in.Config.AcceptedStalenessLimit}, in.staleness, in.filter, in.Now, in.releaseAPIUrl)
+	klog.V(4).Info("Checking streams for all payloads\n")
+	allEmpty, allStale := getEmptyAndStaleStreams(in.AllReleases, nil, &stalenessLimit{defaultLimit: in.Config.AcceptedStalenessLimit}, in.staleness, in.filter, in.Now, in.releaseAPIUrl)
+	for stream, _ := range acceptedEmpty {
+		if _, ok := in.insufficientHistory[stream]; ok {
+			continue
+		}
+		klog.V(4).Infof("Examining stream %s which has no accepted payloads", stream)
+		// if there are no accepted payloads, but the overall payloads set for the stream is not empty
+		// (and especially if the overall payloads are not stale), flag it.  If the overall stream is empty,
+		// we'll flag it further below.
+		if _, ok := allStale[stream]; !ok {
+			streamResult(results, stream).addFinding(FindingNoAccepted, SeverityCritical, 0, "Has no accepted payloads, but the stream contains recently built payloads")
+		} else if _, ok := allEmpty[stream]; !ok {
+			streamResult(results, stream).addFinding(FindingNoAccepted, SeverityCritical, 0, "Has no accepted payloads, but the stream contains built payloads")
+		}
+
+	}
+	for stream, _ := range allEmpty {
+		streamResult(results, stream).addFinding(FindingNoBuilt, SeverityCritical, 0, "Has no built payloads")
+	}
+	return results, nil
+}
+
+// runAcceptedCheck checks whether each stream has recently accepted a payload.
+func runAcceptedCheck(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error) {
+	results := map[string]*StreamReport{}
+	limit := in.Config.AcceptedStalenessLimit
+	_, acceptedStale := getEmptyAndStaleStreams(in.AcceptedReleases, in.acceptedTimes, &stalenessLimit{defaultLimit: limit}, in.staleness, in.filter, in.Now, in.releaseAPIUrl)
+	for stream, age := range acceptedStale {
+		if _, ok := in.insufficientHistory[stream]; ok {
+			continue
+		}
+		streamResult(results, stream).addFinding(FindingAcceptedStale, SeverityWarning, age, fmt.Sprintf("Most recently accepted payload > %.1f days, last accepted was %s", limit.Hours()/24, agoString(age, in.Now, in.Config.AbsoluteDates)))
+	}
+	return results, nil
+}
+
+// runBuiltCheck checks whether each stream has recently built a payload.
+func runBuiltCheck(ctx context.Context, in *CheckInput) (map[string]*StreamReport, error) {
+	results := map[string]*StreamReport{}
+	cfg := in.Config
+	klog.V(4).Infof("Checking streams for very stale payloads\n")
+	_, allVeryStale := getEmptyAndStaleStreams(in.AllReleases, nil, &stalenessLimit{defaultLimit: cfg.BuiltStalenessLimit, byMinor: cfg.BuiltStalenessByMinor, scheme: in.scheme}, in.staleness, in.filter, in.Now, in.releaseAPIUrl)
+	for stream, age := range allVeryStale {
+		if _, ok := in.insufficientHistory[stream]; ok {
+			continue
+		}
+		if cfg.CriticalStalenessLimit > 0 && age.Minutes() > cfg.CriticalStalenessLimit.Minutes() {
+			streamResult(results, stream).addFinding(FindingBuiltStale, SeverityCritical, age, fmt.Sprintf("Most recently built payload was %s, more than the critical limit of %.1f days", agoString(age, in.Now, cfg.AbsoluteDates), cfg.CriticalStalenessLimit.Hours()/24))
+			continue
+		}
+		streamResult(results, stream).addFinding(FindingBuiltStale, SeverityWarning, age, fmt.Sprintf("Most recently built payload was %s", agoString(age, in.Now, cfg.AbsoluteDates)))
+	}
+	return results, nil
+}
+
+// sortPayloads orders each stream's payloads newest first, as the release controller lists them.  Payload names
+// end in their build time, so within a stream they sort by it.
+func sortPayloads(releases map[string][]string) {
+	for _, payloads := range releases {
+		sort.Sort(sort.Reverse(sort.StringSlice(payloads)))
+	}
+}
+
+// mismatchedStreams returns the streams matching the filter which are listed by only the accepted stream, and those
+// listed by only the all stream, in alphabetical order.
+func mismatchedStreams(accepted, all map[string][]string, filter *streamFilter) ([]string, []string) {
+	onlyAccepted, onlyAll := []string{}, []string{}
+	for _, stream := range sortedStreams(accepted) {
+		if _, ok := all[stream]; !ok && filter.matches(stream) {
+			onlyAccepted = append(onlyAccepted, stream)
+		}
+	}
+	for _, stream := range sortedStreams(all) {
+		if _, ok := accepted[stream]; !ok && filter.matches(stream) {
+			onlyAll = append(onlyAll, stream)
+		}
+	}
+	return onlyAccepted, onlyAll
+}
+
+// sortedStreams returns the names of the release streams in alphabetical order, so they are checked, and logged,
+// in the same order every run.
+func sortedStreams(releases map[string][]string) []string {
+	streams := make([]string, 0, len(releases))
+	for stream := range releases {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	return streams
+}
+
+// maintenanceString describes the stream's maintenance.
+func (r *StreamReport) maintenanceString(stream string) string {
+	if r.MaintenanceUntil.IsZero() {
+		return stream
+	}
+	return fmt.Sprintf("%s (until %s)", stream, r.MaintenanceUntil.UTC().Format(time.RFC3339))
+}
+
+// Copy returns a copy of the report whose streams can be modified, e.g. by ApplyState, without modifying the
+// streams of the original, so a report shared by several callers can be tracked and rendered by each of them.
+func (rep *Report) Copy() *Report {
+	c := *rep
+	c.Streams = make(map[string]*StreamReport, len(rep.Streams))
+	for stream, r := range rep.Streams {
+		sr := *r
+		if r.HealthyMessages != nil {
+			sr.HealthyMessages = append([]string{}, r.HealthyMessages...)
+		}
+		if r.Findings != nil {
+			sr.Findings = append([]Finding{}, r.Findings...)
+		}
+		c.Streams[stream] = &sr
+	}
+	return &c
+}
+
+// sortedStreams returns the streams from the newest minor version to the oldest, or when sorting by severity
+// from the streams with critical findings to the healthy streams, and then by version.
+func (rep *Report) sortedStreams() []string {
+	streams := []string{}
+	for stream, _ := range rep.Streams {
+		streams = append(streams, stream)
+	}
+
+	sort.Strings(streams)
+	sort.SliceStable(streams, func(i, j int) bool {
+		if rep.sortBy == SortSeverity {
+			if iRank, jRank := rep.Streams[streams[i]].severityRank(), rep.Streams[streams[j]].severityRank(); iRank != jRank {
+				return iRank > jRank
+			}
+		}
+		iVersion := rep.scheme.versionMinor(streams[i])
+		jVersion := rep.scheme.versionMinor(streams[j])
+		// this deliberately reverses the standard sorting order so we
+		// get highest to lowest.
+		return iVersion > jVersion
+
+	})
+	return streams
+}
+
+// ReleaseStreamURL returns the link to the stream's page on the release controller serving the release API.  The url
+// may have a trailing slash, e.g. when it wasn't normalized by NormalizeReleaseAPIUrl.
+func ReleaseStreamURL(releaseAPIUrl, stream string) string {
+	return strings.TrimRight(releaseAPIUrl, "/") + "/#" + stream
+}
+
+// StreamString renders the link to the stream followed by its findings, and its passed checks if includeHealthy is set.
+// Unless includeHealthy is set, the missing patch and minor upgrade findings are combined into one, which says
+// whether the stream is missing both or only one of them.
+func (rep *Report) StreamString(stream string, includeHealthy bool) string {
+	output := ReleaseStreamURL(rep.ReleaseAPIUrl, stream) + "\n"
+
+	unhealthyPrefix := ""
+	if includeHealthy {
+		unhealthyPrefix = "*WARNING:* "
+	}
+	r := rep.Streams[stream]
+	upgrades, upgradesSummary := []Finding{}, ""
+	if !includeHealthy {
+		upgrades, upgradesSummary = r.upgradeFindingsSummary()
+	}
+	for _, f := range r.Findings {
+		message, details := f.Message, []Finding{f}
+		if upgradesSummary != "" && (f.Kind == FindingNoPatchUpgrade || f.Kind == FindingNoMinorUpgrade) {
+			if f.Kind != upgrades[0].Kind {
+				// already rendered with the first of the combined findings
+				continue
+			}
+			message, details = upgradesSummary, upgrades
+		}
+		prefix := unhealthyPrefix
+		if rep.SlackEmoji {
+			prefix = ":warning: "
+			if f.Severity == SeverityCritical {
+				prefix = ":red_circle: "
+			} else if f.Severity == SeverityInfo {
+				prefix = ":information_source: "
+			}
+		}
+		output += fmt.Sprintf("  * %s%s\n", prefix, message)
+		for _, d := range details {
+			if d.JobURL != "" {
+				output += fmt.Sprintf("    - Most recent failed attempt: %s\n", d.JobURL)
+			}
+			if rep.withHints && d.Hint() != "" {
+				output += fmt.Sprintf("    - Next step: %s\n", d.Hint())
+			}
+		}
+	}
+
+	if includeHealthy {
+		healthyPrefix := ""
+		if rep.SlackEmoji {
+			healthyPrefix = ":large_green_circle: "
+		}
+		for _, o := range rep.Streams[stream].HealthyMessages {
+			output += fmt.Sprintf("  * %s%s\n", healthyPrefix, o)
+		}
+	}
+	return output
+}
+
+// upgradeFindingsSummary returns the stream's missing patch and minor upgrade findings, and a message describing
+// the combination, e.g. that the stream has a patch upgrade but no minor upgrade, which is often expected near a
+// release boundary.  The message is "" if the stream isn't missing either upgrade.
+func (r *StreamReport) upgradeFindingsSummary() ([]Finding, string) {
+	findings := []Finding{}
+	missingPatch, missingMinor := false, false
+	for _, f := range r.Findings {
+		switch f.Kind {
+		case FindingNoPatchUpgrade:
+			missingPatch = true
+		case FindingNoMinorUpgrade:
+			missingMinor = true
+		default:
+			continue
+		}
+		findings = append(findings, f)
+	}
+	switch {
+	case missingPatch && missingMinor:
+		return findings, "Does not have a recent valid patch or minor level upgrade"
+	case missingMinor:
+		return findings, "Has a recent valid patch level upgrade, but no recent valid minor level upgrade path"
+	case missingPatch:
+		return findings, "Has a recent valid minor level upgrade, but no recent valid patch level upgrade"
+	}
+	return findings, ""
+}
+
+func (rep *Report) String(includeHealthy bool) string {
+	streams := rep.sortedStreams()
+
+	output := ""
+	suppressed := []string{}
+	maintenance := []string{}
+	lastMinor := -1
+
+	for _, stream := range streams {
+		if len(rep.Streams[stream].Findings) == 0 && !includeHealthy {
+			continue // nothing to say about this healthy stream
+		}
+		if rep.Streams[stream].Suppressed {
+			suppressed = append(suppressed, stream)
+			continue
+		}
+		if rep.Streams[stream].Maintenance && len(rep.Streams[stream].Findings) > 0 {
+			maintenance = append(maintenance, rep.Streams[stream].maintenanceString(stream))
+			continue
+		}
+
+		// when the streams are sorted by minor version, group them under a header for each minor
+		if minor := rep.scheme.versionMinor(stream); rep.sortBy != SortSeverity && minor != lastMinor {
+			output += fmt.Sprintf("=== %s ===\n\n", rep.scheme.FormatMinor(minor))
+			lastMinor = minor
+		}
+		output += rep.StreamString(stream, includeHealthy) + "\n"
+	}
+	if len(suppressed) > 0 {
+		output += fmt.Sprintf("Not repeating already reported unhealthy streams with no new findings: %s\n", strings.Join(suppressed, ", "))
+	}
+	if len(maintenance) > 0 {
+		output += fmt.Sprintf("Not reporting the findings of streams under maintenance: %s\n", strings.Join(maintenance, ", "))
+	}
+	if !includeHealthy && len(output) == 0 {
+		output += "No unhealthy payload streams detected\n"
+	}
+	if rep.Diff != nil {
+		output = rep.Diff.String() + "\n" + output
+	}
+	if rep.acceptanceRates != nil {
+		output += "\n" + rep.acceptanceRatesString()
+	}
+	if rep.payloadCounts != nil {
+		output += "\n" + rep.payloadCountsString()
+	}
+	if rep.payloadSpans != nil {
+		output += "\n" + rep.payloadSpansString(rep.now)
+	}
+	if rep.promotionLatencies != nil {
+		output += "\n" + rep.promotionLatenciesString()
+	}
+	if rep.graphSummary != nil {
+		output += "\n" + rep.graphSummaryString()
+	}
+	output += fmt.Sprintf("\nIgnored releases older than %s.z and newer than %s.z\n", rep.scheme.FormatMinor(rep.OldestMinor), rep.scheme.FormatMinor(rep.NewestMinor))
+	if !rep.AsOf.IsZero() {
+		output += fmt.Sprintf("Reported as of %s\n", rep.AsOf.UTC().Format(time.RFC3339))
+	}
+	output += rep.provenanceString()
+	if len(rep.checks) > 0 {
+		output += fmt.Sprintf("Only ran the %s checks\n", strings.Join(rep.checks, ", "))
+	}
+	if len(rep.includeStreams) > 0 {
+		output += fmt.Sprintf("Ignored streams not matching %s\n", strings.Join(rep.includeStreams, ", "))
+	}
+	if len(rep.excludeStreams) > 0 {
+		output += fmt.Sprintf("Ignored streams matching %s\n", strings.Join(rep.excludeStreams, ", "))
+	}
+	return output
+}
+
+// provenanceString describes when, from where and with which limits the report was generated, so a report
+// pasted elsewhere can be reproduced.
+func (rep *Report) provenanceString() string {
+	output := ""
+	if !rep.generatedAt.IsZero() {
+		source := rep.ReleaseAPIUrl
+		if rep.fromDir != "" {
+			source = fmt.Sprintf("the responses saved in %s", rep.fromDir)
+		}
+		output += fmt.Sprintf("Generated at %s (%s) from %s\n", rep.generatedAt.UTC().Format(time.RFC3339), rep.generatedAt.Local().Format("2006-01-02 15:04:05 MST"), source)
+	}
+	built := rep.builtStalenessLimit.String()
+	if len(rep.builtStalenessByMinor) > 0 {
+		built += fmt.Sprintf(" (per minor overrides: %s)", rep.builtStalenessByMinor.String())
+	}
+	if rep.criticalStalenessLimit > 0 {
+		built += fmt.Sprintf(", critical after %s", rep.criticalStalenessLimit)
+	}
+	output += fmt.Sprintf("Staleness limits: accepted %s, built %s, upgrades %s", rep.acceptedStalenessLimit, built, rep.upgradeStalenessLimit)
+	if rep.businessDaysOnly {
+		output += ", counting business days only"
+	}
+	if rep.timestampSource == TimestampAccepted {
+		output += ", aging accepted payloads by when they were accepted"
+	}
+	if rep.freeze != nil {
+		output += fmt.Sprintf("\nRelease freeze from %s to %s, stale payloads are informational", rep.freeze.start, rep.freeze.end)
+	}
+	return output + "\n"
+}
+
+// getReleaseStream fetches the release stream at path from the release controller, or reads it from the named file
+// in the FromDir.  The acceptance time of each payload which has one is also returned, and the url the release
+// controller has moved to, if the request was redirected to another one.
+func (cfg *Config) getReleaseStream(ctx context.Context, releaseAPIUrl, path, name string) (map[string][]string, map[string]time.Time, string, error) {
+	if cfg.FromDir != "" {
+		releases, times, err := readReleaseStream(filepath.Join(cfg.FromDir, name))
+		return releases, times, "", err
+	}
+	url := apiURL(releaseAPIUrl, path)
+	releases, times, servedFrom, err := getReleaseStream(ctx, cfg.apiClient(), url, cfg.dumpRawPath(name), cfg.MaxResponseBytes)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return releases, times, movedReleaseAPIUrl(releaseAPIUrl, path, url, servedFrom), nil
+}
+
+// movedReleaseAPIUrl returns the url the release controller at releaseAPIUrl has moved to, if the request for its
+// API path at url was served from elsewhere, e.g. after it was migrated to a new hostname.  If the controller's new
+// url can't be worked out from where the request was redirected to, the redirect is only logged.
+func movedReleaseAPIUrl(releaseAPIUrl, path, url, servedFrom string) string {
+	if servedFrom == url {
+		return ""
+	}
+	u, err := neturl.Parse(servedFrom)
+	if err != nil || !strings.HasSuffix(u.Path, path) {
+		klog.Warningf("The release API request for %s was redirected to %s, links in the report may point at the old release controller", url, servedFrom)
+		return ""
+	}
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = strings.TrimSuffix(u.Path, path), "", "", ""
+	moved, err := NormalizeReleaseAPIUrl(u.String())
+	if err != nil || moved == releaseAPIUrl {
+		return ""
+	}
+	return moved
+}
+
+// readReleaseStream reads a release stream response saved in file.
+func readReleaseStream(file string) (map[string][]string, map[string]time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading raw data: %v", err)
+	}
+	defer f.Close()
+	releases, times, err := decodeReleaseStreams(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding releases from %s: %v", file, err)
+	}
+	klog.V(2).Infof("Read raw data from %s\n", file)
+	return releases, times, nil
+}
+
+// getReleaseStream fetches the payloads in each release stream, and returns the url the response was served from,
+// which differs from url if the request was redirected.  If dumpFile is set, the raw response is also written to it.
+// The all releases response is large, so it is decoded a stream at a time as it is read rather than being read into
+// memory first.
+func getReleaseStream(ctx context.Context, client *http.Client, url, dumpFile string, maxBytes int64) (map[string][]string, map[string]time.Time, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error creating request for %s: %v", url, err)
+	}
+	// the all releases response in particular is large, so ask for it compressed
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, "", &FetchError{Kind: FetchErrorNetwork, What: "releases", URL: url, Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, nil, "", statusError(res, "releases", url)
+	}
+
+	body, err := newBodyReader(res, "releases", url, maxBytes)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer body.Close()
+	var reader io.Reader = body
+	if dumpFile != "" {
+		f, err := os.Create(dumpFile)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("error writing raw data to %s: %v", dumpFile, err)
+		}
+		defer f.Close()
+		reader = io.TeeReader(body, f)
+	}
+
+	releases, times, err := decodeReleaseStreams(reader)
+	if err != nil {
+		return nil, nil, "", body.fetchError(err)
+	}
+	if dumpFile != "" {
+		klog.V(2).Infof("Wrote raw data to %s\n", dumpFile)
+	}
+
+	return releases, times, res.Request.URL.String(), nil
+}
+
+// decodeReleaseStreams decodes a JSON object mapping each stream to its payloads a token at a time, so only the
+// decoded payload names are held in memory.  In case the endpoints diverge from that structure, an array of
+// {"name": STREAM, "payloads": [...]} objects is also accepted, as are payloads given as {"name": PAYLOAD} objects.
+// Payload objects may give the RFC3339 time the payload was accepted as their acceptedAt, which is returned for each
+// payload which has one.
+func decodeReleaseStreams(r io.Reader) (map[string][]string, map[string]time.Time, error) {
+	releases := make(map[string][]string)
+	times := make(map[string]time.Time)
+	add := func(stream string, payloads []string) {
+		name := normalizeStreamName(stream)
+		if name == "" {
+			klog.Warningf("Ignoring the %d payloads of stream %q, which has no name", len(payloads), stream)
+			return
+		}
+		existing, duplicate := releases[name]
+		if !duplicate {
+			releases[name] = payloads
+			return
+		}
+		klog.Warningf("Merging the payloads of stream %q into stream %q, which it duplicates", stream, name)
+		releases[name] = mergePayloads(existing, payloads)
+	}
+
+	dec := json.NewDecoder(r)
+	token, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return nil, nil, err
+			}
+			stream, ok := token.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("expected a stream name, found %v", token)
+			}
+			payloads, err := decodePayloadNames(dec, times)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error decoding the payloads of stream %s: %w", stream, err)
+			}
+			add(stream, payloads)
+		}
+		if err := expectDelim(dec, '}'); err != nil {
+			return nil, nil, err
+		}
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := expectDelim(dec, '{'); err != nil {
+				return nil, nil, fmt.Errorf("error decoding stream %d of the array of streams: %w", i, err)
+			}
+			stream, payloads := "", []string{}
+			err := decodeObject(dec, func(key string) error {
+				var err error
+				switch key {
+				case "name":
+					stream, err = decodeString(dec)
+				case "payloads":
+					payloads, err = decodePayloadNames(dec, times)
+				default:
+					err = dec.Decode(&json.RawMessage{})
+				}
+				return err
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("error decoding stream %d of the array of streams: %w", i, err)
+			}
+			if stream == "" {
+				return nil, nil, fmt.Errorf("stream %d of the array of streams has no name", i)
+			}
+			add(stream, payloads)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("expected a JSON object mapping each stream to its payloads, found a JSON %s", jsonKind(token))
+	}
+	return releases, times, nil
+}
+
+// decodePayloadNames decodes the next value of dec, an array of payload names or of objects with a name, recording
+// the acceptance time of the payload objects which have one in times.
+func decodePayloadNames(dec *json.Decoder, times map[string]time.Time) ([]string, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		// a stream without payloads
+		return nil, nil
+	}
+	if token != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array of payloads, found a JSON %s", jsonKind(token))
+	}
+	payloads := []string{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case json.Delim('{'):
+			payload, acceptedAt := "", ""
+			err := decodeObject(dec, func(key string) error {
+				var err error
+				switch key {
+				case "name":
+					payload, err = decodeString(dec)
+				case "acceptedAt":
+					acceptedAt, err = decodeString(dec)
+				default:
+					err = dec.Decode(&json.RawMessage{})
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			if payload == "" {
+				return nil, fmt.Errorf("found a payload object without a name")
+			}
+			if acceptedAt != "" {
+				ts, err := time.Parse(time.RFC3339, acceptedAt)
+				if err != nil {
+					return nil, fmt.Errorf("invalid acceptedAt time of payload %s: %v", payload, err)
+				}
+				times[payload] = ts
+			}
+			payloads = append(payloads, payload)
+		default:
+			payload, ok := token.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a payload name, found a JSON %s", jsonKind(token))
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+	if err := expectDelim(dec, ']'); err != nil {
+		return nil, err
+	}
+	return payloads, nil
+}
+
+// decodeObject calls decodeValue with each key of the object whose opening delimiter was just read from dec,
+// which must decode the key's value, and then reads the closing delimiter.
+func decodeObject(dec *json.Decoder, decodeValue func(key string) error) error {
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("expected an object key, found %v", token)
+		}
+		if err := decodeValue(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// decodeString decodes the next value of dec, which must be a string.
+func decodeString(dec *json.Decoder) (string, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	s, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a JSON string, found a JSON %s", jsonKind(token))
+	}
+	return s, nil
+}
+
+// jsonKind returns the kind of JSON value a token starts, e.g. "array".
+func jsonKind(token json.Token) string {
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '[' {
+			return "array"
+		}
+		return "object"
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", token)
+}
+
+// normalizeStreamName trims whitespace from a stream name and lower cases it, so names which differ only in
+// those ways are treated as the same stream.  The release controller's stream names are always lower case.
+func normalizeStreamName(stream string) string {
+	return strings.ToLower(strings.TrimSpace(stream))
+}
+
+// mergePayloads returns the payloads in either list, without duplicates.
+func mergePayloads(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	merged := make([]string, 0, len(a)+len(b))
+	for _, payloads := range [][]string{a, b} {
+		for _, payload := range payloads {
+			if seen[payload] {
+				continue
+			}
+			seen[payload] = true
+			merged = append(merged, payload)
+		}
+	}
+	return merged
+}
+
+// expectDelim reads the next token from dec, returning an error if it isn't the delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, found %v", delim, token)
+	}
+	return nil
+}
+
+// payloadsBuiltBy returns the payloads of each stream which were built no later than t.
+func payloadsBuiltBy(releases map[string][]string, t time.Time) map[string][]string {
+	filtered := make(map[string][]string, len(releases))
+	for stream, payloads := range releases {
+		filtered[stream] = []string{}
+		for _, payload := range payloads {
+			ts, err := getPayloadTimestamp(payload)
+			if err != nil {
+				klog.Error(err.Error())
+				continue
+			}
+			if ts.After(t) {
+				klog.V(4).Infof("Ignoring payload %s in stream %s which was built after %s\n", payload, stream, t)
+				continue
+			}
+			filtered[stream] = append(filtered[stream], payload)
+		}
+	}
+	return filtered
+}
+
+// getInsufficientHistoryStreams returns the number of payloads in each stream which has some, but fewer than
+// minPayloads, payloads.  Such streams are too new for their staleness to be meaningful.
+func getInsufficientHistoryStreams(releases map[string][]string, minPayloads int, filter *streamFilter) map[string]int {
+	insufficient := make(map[string]int)
+	for stream, payloads := range releases {
+		if !filter.matches(stream) {
+			continue
+		}
+		// a stream with no payloads at all is always reported
+		if len(payloads) > 0 && len(payloads) < minPayloads {
+			klog.V(4).Infof("Release stream %s has only %d payloads, not checking it for staleness\n", stream, len(payloads))
+			insufficient[stream] = len(payloads)
+		}
+	}
+	return insufficient
+}
+
+func getEmptyAndStaleStreams(releases map[string][]string, times map[string]time.Time, limit *stalenessLimit, clock *stalenessClock, filter *streamFilter, now time.Time, releaseAPIUrl string) (map[string]struct{}, map[string]time.Duration) {
+	emptyStreams := make(map[string]struct{})
+	staleStreams := make(map[string]time.Duration)
+	for _, stream := range sortedStreams(releases) {
+		if !filter.matches(stream) {
+			continue
+		}
+		if len(releases[stream]) == 0 {
+			klog.V(4).Infof("Release %s has no payloads\n", stream)
+			emptyStreams[stream] = struct{}{}
+			continue
+		}
+		threshold := limit.forStream(stream)
+		freshPayload := false
+		var newest time.Time
+		for _, payload := range releases[stream] {
+			ts, err := payloadTime(payload, times)
+			if err != nil {
+				klog.Errorf(err.Error())
+				continue
+			}
+			delta := clock.age(ts, now)
+			if delta.Minutes() < threshold.Minutes() {
+				klog.V(4).Infof("Release %s in stream %s is fresh: %0.1f hours old (threshold is %0.1f)\n", payload, stream, delta.Hours(), threshold.Hours())
+				freshPayload = true
+			} else {
+				klog.V(4).Infof("Release %s in stream %s is stale: %0.1f hours old (threshold is %0.1f)\n", payload, stream, delta.Hours(), threshold.Hours())
+			}
+			if ts.After(newest) {
+				newest = ts
+			}
+		}
+		if !freshPayload {
+			klog.V(4).Infof("Release stream %s does not have a recent payload: %s\n", stream, ReleaseStreamURL(releaseAPIUrl, stream))
+			staleStreams[stream] = now.Sub(newest)
+		}
+	}
+	return emptyStreams, staleStreams
+}
+
+func getPayloadTimestamp(payload string) (time.Time, error) {
+	m := extractDateRegex.FindStringSubmatch(payload)
+	if m == nil || len(m) != 7 {
+		return time.Time{}, fmt.Errorf("error: could not extract date from payload %s", payload)
+	}
+	//fmt.Printf("Release %s has date %s\n", r, m[0])
+	//t := time.Date(m[1], m[2], m[3], m[4], m[5], m[6], 0, time.UTC)
+	payloadTime, err := time.Parse("2006-01-02-150405 MST", m[0]+" EST")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error: failed to parse time string %s: %v", m[0], err)
+	}
+	//fmt.Printf("%v\n", t)
+	return payloadTime, nil
+
+}
+
+const (
+	// names of the files the raw release API responses are written to by --dump-raw
+	acceptedReleasesFile = "accepted.json"
+	allReleasesFile      = "all.json"
+	upgradeGraphFile     = "graph-%s.json"
+)
+
+// dumpRawPath returns the path to write the raw response data for the named file to, or "" if raw data
+// should not be written.
+func (cfg *Config) dumpRawPath(name string) string {
+	if cfg.DumpRawDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.DumpRawDir, name)
+}
+
+// errResponseTooLarge is returned reading a response body larger than the configured maximum.
+var errResponseTooLarge = errors.New("response is too large")
+
+// bodyReader reads a response body, decompressing it if the server compressed it, and failing once more than
+// the maximum number of bytes have been read.  It records any error reading the body, so it can be told apart
+// from the body not decoding.
+type bodyReader struct {
+	reader    io.Reader
+	gz        *gzip.Reader
+	remaining int64
+	maxBytes  int64
+	readErr   error
+	what, url string
+}
+
+// newBodyReader returns a reader for the body of res.  Setting Accept-Encoding on a request disables the
+// transparent decompression done by the http client, so it has to be done here.
+func newBodyReader(res *http.Response, what, url string, maxBytes int64) (*bodyReader, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxResponseBytes
+	}
+	b := &bodyReader{reader: res.Body, remaining: maxBytes, maxBytes: maxBytes, what: what, url: url}
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, &FetchError{Kind: FetchErrorDecode, What: what, URL: url, Err: err}
+		}
+		b.gz = gz
+		b.reader = gz
+	}
+	return b, nil
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.readErr != nil {
+		return 0, b.readErr
+	}
+	// read one byte more than the maximum, so a body of exactly the maximum size isn't an error
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.reader.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		n, err = n+int(b.remaining), errResponseTooLarge
+	}
+	if err != nil && err != io.EOF {
+		b.readErr = err
+	}
+	return n, err
+}
+
+func (b *bodyReader) Close() error {
+	if b.gz != nil {
+		return b.gz.Close()
+	}
+	return nil
+}
+
+// fetchError converts an error reading or decoding the body to a *FetchError.
+func (b *bodyReader) fetchError(err error) error {
+	switch {
+	case errors.Is(b.readErr, errResponseTooLarge):
+		return &FetchError{Kind: FetchErrorDecode, What: b.what, URL: b.url, Err: fmt.Errorf("%w, the limit is %d bytes", errResponseTooLarge, b.maxBytes)}
+	case errors.Is(b.readErr, gzip.ErrChecksum) || errors.Is(b.readErr, gzip.ErrHeader):
+		return &FetchError{Kind: FetchErrorDecode, What: b.what, URL: b.url, Err: b.readErr}
+	case b.readErr != nil:
+		return &FetchError{Kind: FetchErrorNetwork, What: b.what, URL: b.url, Err: b.readErr}
+	default:
+		return &FetchError{Kind: FetchErrorDecode, What: b.what, URL: b.url, Err: err}
+	}
+}
+
+// readBody reads the whole response body, decompressing it if the server compressed it.
+func readBody(res *http.Response, what, url string, maxBytes int64) ([]byte, error) {
+	reader, err := newBodyReader(res, what, url, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, reader.fetchError(err)
+	}
+	return body, nil
+}
+
+// dumpRaw writes data to the file, if a file is set.
+func dumpRaw(file string, data []byte) error {
+	if file == "" {
+		return nil
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return fmt.Errorf("error writing raw data to %s: %v", file, err)
+	}
+	klog.V(2).Infof("Wrote raw data to %s\n", file)
+	return nil
+}
+
+type GraphNode struct {
+	Version string `json:"version"`
+	Payload string `json:"payload"`
+	From    int
+}
+
+type GraphEdge [2]int
+
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphMap map[string][]string
+
+// graphChannel returns the upgrade graph channel to check upgrades against.
+func (cfg *Config) graphChannel() string {
+	if cfg.GraphChannel == "" {
+		return defaultGraphChannel
+	}
+	return cfg.GraphChannel
+}
+
+// graphURL returns the url of the upgrade graph for the channel.
+func (cfg *Config) graphURL(releaseAPIUrl, channel string) string {
+	path, param := cfg.GraphPath, cfg.GraphChannelParam
+	if path == "" {
+		path = defaultGraphPath
+	}
+	if param == "" {
+		param = defaultGraphChannelParam
+	}
+	return apiURL(releaseAPIUrl, path) + "?" + neturl.Values{param: []string{channel}}.Encode()
+}
+
+// NormalizeReleaseAPIUrl returns the release controller url in the form API paths are joined to, without a
+// trailing slash, or an error if it isn't an absolute http or https url.  A path is allowed, e.g. for a
+// controller served behind a proxy, but a query or fragment is not.
+func NormalizeReleaseAPIUrl(raw string) (string, error) {
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid release API url %q: %v", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid release API url %q, must be an http or https url", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid release API url %q, must not have a query, fragment or credentials", raw)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// apiURL returns the url of the API path on the release controller, whose url must have been normalized by
+// NormalizeReleaseAPIUrl.  The elements must already be escaped.
+func apiURL(releaseAPIUrl string, elem ...string) string {
+	u, err := neturl.JoinPath(releaseAPIUrl, elem...)
+	if err != nil {
+		// the normalized url has already been parsed successfully, so this can't happen, but leave the request
+		// to fail with the url if it does
+		return releaseAPIUrl + strings.Join(elem, "/")
+	}
+	return u
+}
+
+// releaseAPIUrl returns the url of the release controller to check.
+func (cfg *Config) releaseAPIUrl() (string, error) {
+	if cfg.ReleaseAPIUrl != "" {
+		return NormalizeReleaseAPIUrl(cfg.ReleaseAPIUrl)
+	}
+	flavor, err := FlavorByName(cfg.Flavor)
+	if err != nil {
+		return "", err
+	}
+	releaseAPIUrl, found := flavor.ReleaseAPIUrls[cfg.Arch]
+	if !found {
+		return "", fmt.Errorf("unknown architecture: %s", cfg.Arch)
+	}
+	return NormalizeReleaseAPIUrl(releaseAPIUrl)
+}
+
+// FetchAcceptedReleases fetches the accepted payloads in each release stream from the release controller for the
+// configured architecture.
+func FetchAcceptedReleases(ctx context.Context, cfg *Config) (map[string][]string, error) {
+	releaseAPIUrl, err := cfg.releaseAPIUrl()
+	if err != nil {
+		return nil, err
+	}
+	releases, _, _, err := cfg.getReleaseStream(ctx, releaseAPIUrl, acceptedReleasePath, acceptedReleasesFile)
+	return releases, err
+}
+
+// FetchUpgradeGraph fetches the upgrade graph of the configured channel from the release controller for the
+// configured architecture, mapping each version to the versions which upgrade to it.
+func FetchUpgradeGraph(ctx context.Context, cfg *Config) (GraphMap, error) {
+	releaseAPIUrl, err := cfg.releaseAPIUrl()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.fetchUpgradeGraph(ctx, releaseAPIUrl)
+}
+
+func (cfg *Config) fetchUpgradeGraph(ctx context.Context, releaseAPIUrl string) (GraphMap, error) {
+	channel := cfg.graphChannel()
+	if cfg.FromDir != "" {
+		return readUpgradeGraph(filepath.Join(cfg.FromDir, fmt.Sprintf(upgradeGraphFile, channel)), channel)
+	}
+	return getUpgradeGraph(ctx, cfg.apiClient(), cfg.graphURL(releaseAPIUrl, channel), channel, cfg.GraphAccept, cfg.dumpRawPath(fmt.Sprintf(upgradeGraphFile, channel)), cfg.MaxResponseBytes)
+}
+
+// getUpgradeGraph fetches the upgrade graph for the channel from url, mapping each version to the versions which
+// upgrade to it.  If accept is set, it is sent as the Accept header, e.g. for a Cincinnati server which negotiates
+// the graph format.  If dumpFile is set, the raw response is also written to it.
+func getUpgradeGraph(ctx context.Context, client *http.Client, url, channel, accept, dumpFile string, maxBytes int64) (GraphMap, error) {
+	graphMap := GraphMap{}
+
+	graph := Graph{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return graphMap, fmt.Errorf("error creating request for %s: %v", url, err)
+	}
+	// the all releases response in particular is large, so ask for it compressed
+	req.Header.Set("Accept-Encoding", "gzip")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return graphMap, &FetchError{Kind: FetchErrorNetwork, What: "upgrade graph", URL: url, Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return graphMap, statusError(res, "upgrade graph", url)
+	}
+
+	body, err := readBody(res, "upgrade graph", url, maxBytes)
+	if err != nil {
+		return graphMap, err
+	}
+	if err := dumpRaw(dumpFile, body); err != nil {
+		return graphMap, err
+	}
+
+	err = json.Unmarshal(body, &graph)
+	if err != nil {
+		return graphMap, &FetchError{Kind: FetchErrorDecode, What: "upgrade graph", URL: url, Err: err}
+	}
+	return graph.versionMap(channel, url), nil
+}
+
+// readUpgradeGraph reads an upgrade graph response for the channel saved in file, mapping each version to the
+// versions which upgrade to it.
+func readUpgradeGraph(file, channel string) (GraphMap, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return GraphMap{}, fmt.Errorf("error reading raw data: %v", err)
+	}
+	graph := Graph{}
+	if err := json.Unmarshal(body, &graph); err != nil {
+		return GraphMap{}, fmt.Errorf("error decoding upgrade graph from %s: %v", file, err)
+	}
+	klog.V(2).Infof("Read raw data from %s\n", file)
+	return graph.versionMap(channel, file), nil
+}
+
+// versionMap maps each version in the graph to the versions which upgrade to it, ignoring edges to nodes which
+// don't exist.  source describes where the graph came from in warnings.
+func (graph *Graph) versionMap(channel, source string) GraphMap {
+	graphMap := GraphMap{}
+	invalid := 0
+	for _, edge := range graph.Edges {
+		from := edge[0]
+		to := edge[1]
+		if from < 0 || from >= len(graph.Nodes) || to < 0 || to >= len(graph.Nodes) {
+			klog.V(4).Infof("Ignoring upgrade graph edge %d -> %d which references a node outside the %d nodes in the graph\n", from, to, len(graph.Nodes))
+			invalid++
+			continue
+		}
+		graph.Nodes[to].From = from
+		if _, ok := graphMap[graph.Nodes[to].Version]; !ok {
+			graphMap[graph.Nodes[to].Version] = []string{graph.Nodes[from].Version}
+		} else {
+			graphMap[graph.Nodes[to].Version] = append(graphMap[graph.Nodes[to].Version], graph.Nodes[from].Version)
+		}
+	}
+	// the edge order isn't meaningful, so don't let it change which upgrade is reported
+	for _, from := range graphMap {
+		sort.Strings(from)
+	}
+	if invalid > 0 {
+		klog.Warningf("Ignored %d of %d edges in the %s upgrade graph from %s which reference nodes that don't exist", invalid, len(graph.Edges), channel, source)
+	}
+
+	return graphMap
+}
+
+// found is a kind of upgrade found in the graph: the last one seen, and the distinct versions upgraded from.
+type found struct {
+	Version string
+	Age     time.Duration
+	// Sources are the distinct versions upgraded from
+	Sources map[string]struct{}
+}
+
+// addUpgrade records an upgrade from the version to a payload of the age, returning the updated found, which
+// is created if it is nil.  Version and Age describe the most recent upgrade recorded, the first one recorded
+// if several are as recent.
+func (f *found) addUpgrade(from string, age time.Duration) *found {
+	if f == nil {
+		f = &found{Version: from, Age: age, Sources: map[string]struct{}{}}
+	} else if age < f.Age {
+		f.Version, f.Age = from, age
+	}
+	f.Sources[from] = struct{}{}
+	return f
+}
+
+// sourceMinors returns the distinct minor versions upgraded from, newest first.
+func (f *found) sourceMinors(scheme *VersionScheme) []int {
+	seen := map[int]bool{}
+	minors := []int{}
+	for from := range f.Sources {
+		if minor := scheme.versionMinor(from); minor >= 0 && !seen[minor] {
+			seen[minor] = true
+			minors = append(minors, minor)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(minors)))
+	return minors
+}
+
+func (f *found) Days() float64 {
+	return f.Age.Hours() / 24
+}
+
+// agoString describes an age as a number of days ago, followed by the UTC time it was at if absolute is set, e.g.
+// "3.2 days ago (2024-06-03 14:22 UTC)", to correlate it with known incidents.
+func agoString(age time.Duration, now time.Time, absolute bool) string {
+	ago := fmt.Sprintf("%.1f days ago", age.Hours()/24)
+	if absolute {
+		ago += fmt.Sprintf(" (%s)", now.Add(-age).UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	return ago
+}
+
+// maxConcurrency returns how many requests or checks may run concurrently.
+func (cfg *Config) maxConcurrency() int {
+	if cfg.MaxConcurrency <= 0 {
+		return DefaultMaxConcurrency
+	}
+	return cfg.MaxConcurrency
+}
+
+// checkUpgrades checks the upgrades of each of the streams.  If maxPayloads is set, only the upgrades to that many
+// of each stream's newest payloads are checked.
+func checkUpgrades(ctx context.Context, scheme *VersionScheme, graph GraphMap, releases map[string][]string, streams []string, stalenessThreshold time.Duration, clock *stalenessClock, now time.Time, checkEUS, absoluteDates bool, maxPayloads, workers int) (map[string]*StreamReport, error) {
+	results := make(map[string]*StreamReport, len(streams))
+
+	// each stream is checked independently, so spread them across a pool of workers.
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	work := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for release := range work {
+				// the payloads are sorted newest first
+				payloads := releases[release]
+				if maxPayloads > 0 && len(payloads) > maxPayloads {
+					payloads = payloads[:maxPayloads]
+				}
+				r := checkStreamUpgrades(scheme, graph, release, payloads, stalenessThreshold, clock, now, checkEUS, absoluteDates)
+				lock.Lock()
+				results[release] = r
+				lock.Unlock()
+			}
+		}()
+	}
+
+	var err error
+	for _, release := range streams {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		work <- release
+	}
+	close(work)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkStreamUpgrades checks whether any recent payload of a stream successfully upgraded from a previous patch
+// and from a previous minor version.  If checkEUS is set, streams for even (EUS) minor versions are also checked
+// for a successful upgrade from the previous EUS minor version (n-2).  If absoluteDates is set, the upgrades' ages
+// are followed by their dates.
+func checkStreamUpgrades(scheme *VersionScheme, graph GraphMap, stream string, payloads []string, stalenessThreshold time.Duration, clock *stalenessClock, now time.Time, checkEUS, absoluteDates bool) *StreamReport {
+	var foundMinor *found
+	var foundPatch *found
+	var foundEUS *found
+	// foundOlderMinor is any upgrade from an older minor, to report which older minors upgrade to the stream
+	var foundOlderMinor *found
+	downgrades := []string{}
+	r := &StreamReport{}
+	for _, payload := range payloads {
+		ts, err := getPayloadTimestamp(payload)
+		if err != nil {
+			klog.Error(err.Error())
+			continue
+		}
+		age := now.Sub(ts)
+		if clock.age(ts, now).Minutes() > stalenessThreshold.Minutes() {
+			continue
+		}
+		toVersion := scheme.versionMinor(payload)
+		if toVersion < 0 {
+			continue
+		}
+
+		for _, from := range graph[payload] {
+
+			fromVersion := scheme.versionMinor(from)
+			if fromVersion < 0 {
+				klog.V(4).Infof("Ignoring upgrade to %s from %s because the minor version could not be determined\n", payload, from)
+				continue
+			}
+
+			klog.V(4).Infof("Payload %s successfully upgrades from %s\n", payload, from)
+			if toVersion < fromVersion {
+				// the graph should only contain upgrades, so this is likely a graph generation bug or mislabeled payload
+				klog.V(4).Infof("Payload %s has a downgrade edge from %s\n", payload, from)
+				downgrades = append(downgrades, fmt.Sprintf("%s to %s", from, payload))
+			}
+			if toVersion == fromVersion {
+				foundPatch = foundPatch.addUpgrade(from, age)
+			}
+			if toVersion > fromVersion {
+				foundOlderMinor = foundOlderMinor.addUpgrade(from, age)
+			}
+			if toVersion == fromVersion+1 {
+				foundMinor = foundMinor.addUpgrade(from, age)
+			}
+			if toVersion == fromVersion+2 && toVersion%2 == 0 {
+				foundEUS = foundEUS.addUpgrade(from, age)
+			}
+		}
+	}
+
+	if foundPatch == nil {
+		r.addFinding(FindingNoPatchUpgrade, SeverityWarning, 0, "Does not have a recent valid patch level upgrade")
+	} else {
+		r.HealthyMessages = append(r.HealthyMessages, fmt.Sprintf("Has a recent valid patch level upgrade from %s %s", foundPatch.Version, agoString(foundPatch.Age, now, absoluteDates)))
+	}
+	if foundMinor == nil {
+		r.addFinding(FindingNoMinorUpgrade, SeverityWarning, 0, "Does not have a recent valid minor level upgrade")
+	} else {
+		r.HealthyMessages = append(r.HealthyMessages, fmt.Sprintf("Has a recent valid minor level upgrade from %s %s", foundMinor.Version, agoString(foundMinor.Age, now, absoluteDates)))
+	}
+	if foundOlderMinor != nil {
+		minors := []string{}
+		for _, minor := range foundOlderMinor.sourceMinors(scheme) {
+			minors = append(minors, scheme.FormatMinor(minor))
+		}
+		r.HealthyMessages = append(r.HealthyMessages, fmt.Sprintf("Has recent valid minor upgrades from %s", strings.Join(minors, ", ")))
+	}
+	if checkEUS && scheme.StreamMinor(stream)%2 == 0 {
+		if foundEUS == nil {
+			r.addFinding(FindingNoEUSUpgrade, SeverityWarning, 0, "Does not have a recent valid EUS (n-2) upgrade")
+		} else {
+			r.HealthyMessages = append(r.HealthyMessages, fmt.Sprintf("Has a recent valid EUS (n-2) upgrade from %s %s", foundEUS.Version, agoString(foundEUS.Age, now, absoluteDates)))
+		}
+	}
+	if len(downgrades) > 0 {
+		r.addFinding(FindingDowngradeEdge, SeverityWarning, 0, fmt.Sprintf("Has unexpected downgrade edges in the upgrade graph: %s", strings.Join(downgrades, ", ")))
+	}
+	return r
+}
+
+// DescribePayload writes what a report generated with the config would extract from the payload name, returning
+// an error for the first piece that can't be parsed.  The payload's age is measured as of Config.AsOf, if it is
+// set, or the Config's Clock.
+func DescribePayload(w io.Writer, cfg *Config, payload string) error {
+	fmt.Fprintf(w, "Payload: %s\n", payload)
+
+	scheme := cfg.Scheme()
+	if matches := scheme.zRelease.FindStringSubmatch(payload); matches == nil {
+		fmt.Fprintf(w, "Stream: not a %d.N.0-0 z-stream of a checked type, so the stream would be ignored\n", scheme.Major)
+	} else {
+		fmt.Fprintf(w, "Stream: %s (minor %s, type %s)\n", matches[0], matches[1], matches[2])
+	}
+
+	matches := scheme.extractMinor.FindStringSubmatch(payload)
+	if matches == nil {
+		return fmt.Errorf("could not extract a %d.N.z minor version from payload %s, upgrades to or from it would be ignored", scheme.Major, payload)
+	}
+	fmt.Fprintf(w, "Minor version: %s\n", matches[1])
+
+	ts, err := getPayloadTimestamp(payload)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Timestamp: %s\n", ts.UTC().Format(time.RFC3339))
+	now := clockOrReal(cfg.Clock).Now()
+	if !cfg.AsOf.IsZero() {
+		now = cfg.AsOf
+	}
+	fmt.Fprintf(w, "Age: %0.1f days\n", now.Sub(ts).Hours()/24)
+	return nil
+}
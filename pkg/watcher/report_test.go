@@ -0,0 +1,365 @@
+package watcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateReportCancelsFetchesOnError(t *testing.T) {
+	cancelled := make(chan string, 2)
+	hang := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.URL.Path
+		case <-time.After(30 * time.Second):
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(acceptedReleasePath, hang)
+	mux.HandleFunc(allReleasePath, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	mux.HandleFunc(defaultGraphPath, hang)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := &Config{ReleaseAPIUrl: srv.URL, OldestMinor: 14, NewestMinor: 16}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := GenerateReport(ctx, cfg)
+
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.StatusCode != http.StatusNotFound || !strings.HasSuffix(fetchErr.URL, allReleasePath) {
+		t.Fatalf("expected the all releases fetch's error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the report to fail as soon as the all releases fetch did, took %s", elapsed)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-cancelled:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected the other fetches to be cancelled, %d of 2 were", i)
+		}
+	}
+}
+
+func TestGraphVersionMap(t *testing.T) {
+	nodes := []GraphNode{{Version: "4.15.0"}, {Version: "4.15.1"}, {Version: "4.14.9"}}
+	tests := []struct {
+		name     string
+		edges    []GraphEdge
+		expected GraphMap
+	}{
+		{
+			name:     "valid edges",
+			edges:    []GraphEdge{{0, 1}, {2, 1}, {2, 0}},
+			expected: GraphMap{"4.15.1": {"4.14.9", "4.15.0"}, "4.15.0": {"4.14.9"}},
+		},
+		{
+			name:     "negative from",
+			edges:    []GraphEdge{{-1, 1}, {0, 1}},
+			expected: GraphMap{"4.15.1": {"4.15.0"}},
+		},
+		{
+			name:     "negative to",
+			edges:    []GraphEdge{{0, -1}, {2, 0}},
+			expected: GraphMap{"4.15.0": {"4.14.9"}},
+		},
+		{
+			name:     "from past the last node",
+			edges:    []GraphEdge{{3, 1}, {0, 1}},
+			expected: GraphMap{"4.15.1": {"4.15.0"}},
+		},
+		{
+			name:     "to past the last node",
+			edges:    []GraphEdge{{0, 3}, {2, 0}},
+			expected: GraphMap{"4.15.0": {"4.14.9"}},
+		},
+		{
+			name:     "only invalid edges",
+			edges:    []GraphEdge{{-1, 0}, {0, 100}},
+			expected: GraphMap{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			graph := &Graph{Nodes: append([]GraphNode{}, nodes...), Edges: tc.edges}
+			got := graph.versionMap("stable", "test")
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestReleaseStreamURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		releaseAPIUrl string
+		stream        string
+		expected      string
+	}{
+		{
+			name:          "amd64",
+			releaseAPIUrl: "https://amd64.ocp.releases.ci.openshift.org",
+			stream:        "4.15.0-0.nightly",
+			expected:      "https://amd64.ocp.releases.ci.openshift.org/#4.15.0-0.nightly",
+		},
+		{
+			name:          "non-amd64 host",
+			releaseAPIUrl: ReleaseAPIUrls["arm64"],
+			stream:        "4.15.0-0.nightly-arm64",
+			expected:      "https://arm64.ocp.releases.ci.openshift.org/#4.15.0-0.nightly-arm64",
+		},
+		{
+			name:          "trailing slash",
+			releaseAPIUrl: "https://multi.ocp.releases.ci.openshift.org/",
+			stream:        "4.15.0-0.nightly-multi",
+			expected:      "https://multi.ocp.releases.ci.openshift.org/#4.15.0-0.nightly-multi",
+		},
+		{
+			name:          "path with a trailing slash",
+			releaseAPIUrl: "https://proxy.example.com/release-controller/",
+			stream:        "4.15.0-0.ci",
+			expected:      "https://proxy.example.com/release-controller/#4.15.0-0.ci",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ReleaseStreamURL(tc.releaseAPIUrl, tc.stream); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDecodeReleaseStreamsNormalizesNames(t *testing.T) {
+	accepted := time.Date(2024, 6, 3, 3, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		body             string
+		expectedReleases map[string][]string
+		expectedTimes    map[string]time.Time
+	}{
+		{
+			name:             "names differing in case",
+			body:             `{"4.15.0-0.nightly": ["4.15.0-0.nightly-2024-06-03-030000"], "4.15.0-0.Nightly": ["4.15.0-0.nightly-2024-06-03-030000", "4.15.0-0.nightly-2024-06-02-030000"]}`,
+			expectedReleases: map[string][]string{"4.15.0-0.nightly": {"4.15.0-0.nightly-2024-06-03-030000", "4.15.0-0.nightly-2024-06-02-030000"}},
+			expectedTimes:    map[string]time.Time{},
+		},
+		{
+			name:             "names differing in whitespace",
+			body:             `{" 4.15.0-0.nightly": ["4.15.0-0.nightly-2024-06-03-030000"], "4.15.0-0.nightly\t": ["4.15.0-0.nightly-2024-06-02-030000"], "4.16.0-0.ci": ["4.16.0-0.ci-2024-06-03-030000"]}`,
+			expectedReleases: map[string][]string{"4.15.0-0.nightly": {"4.15.0-0.nightly-2024-06-03-030000", "4.15.0-0.nightly-2024-06-02-030000"}, "4.16.0-0.ci": {"4.16.0-0.ci-2024-06-03-030000"}},
+			expectedTimes:    map[string]time.Time{},
+		},
+		{
+			name:             "acceptance times of merged payloads",
+			body:             `[{"name": "4.15.0-0.NIGHTLY ", "payloads": [{"name": "4.15.0-0.nightly-2024-06-03-030000", "acceptedAt": "2024-06-03T03:30:00Z"}]}, {"name": "4.15.0-0.nightly", "payloads": ["4.15.0-0.nightly-2024-06-03-030000", "4.15.0-0.nightly-2024-06-02-030000"]}]`,
+			expectedReleases: map[string][]string{"4.15.0-0.nightly": {"4.15.0-0.nightly-2024-06-03-030000", "4.15.0-0.nightly-2024-06-02-030000"}},
+			expectedTimes:    map[string]time.Time{"4.15.0-0.nightly-2024-06-03-030000": accepted},
+		},
+		{
+			name:             "whitespace only name",
+			body:             `{"  ": ["4.15.0-0.nightly-2024-06-03-030000"], "4.15.0-0.nightly": ["4.15.0-0.nightly-2024-06-02-030000"]}`,
+			expectedReleases: map[string][]string{"4.15.0-0.nightly": {"4.15.0-0.nightly-2024-06-02-030000"}},
+			expectedTimes:    map[string]time.Time{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			releases, times, err := decodeReleaseStreams(strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(releases, tc.expectedReleases) {
+				t.Errorf("expected streams %v, got %v", tc.expectedReleases, releases)
+			}
+			if !reflect.DeepEqual(times, tc.expectedTimes) {
+				t.Errorf("expected acceptance times %v, got %v", tc.expectedTimes, times)
+			}
+		})
+	}
+}
+
+func TestNormalizeStreamName(t *testing.T) {
+	for stream, expected := range map[string]string{
+		"4.15.0-0.nightly":      "4.15.0-0.nightly",
+		"4.15.0-0.Nightly":      "4.15.0-0.nightly",
+		" 4.15.0-0.NIGHTLY\n":   "4.15.0-0.nightly",
+		"\t4.16.0-0.ci-arm64  ": "4.16.0-0.ci-arm64",
+		"   ":                   "",
+	} {
+		if got := normalizeStreamName(stream); got != expected {
+			t.Errorf("expected %q to be normalized to %q, got %q", stream, expected, got)
+		}
+	}
+}
+
+// releaseStreamsBody returns a release API response with the streams, each with the payloads.
+func releaseStreamsBody(streams, payloads int) []byte {
+	body := &bytes.Buffer{}
+	body.WriteString("{")
+	for s := 0; s < streams; s++ {
+		if s > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(body, `"4.%d.0-0.nightly":[`, s)
+		for p := 0; p < payloads; p++ {
+			if p > 0 {
+				body.WriteString(",")
+			}
+			fmt.Fprintf(body, `"4.%d.0-0.nightly-2024-06-03-%06d"`, s, p)
+		}
+		body.WriteString("]")
+	}
+	body.WriteString("}")
+	return body.Bytes()
+}
+
+func TestGetReleaseStreamMaxBytes(t *testing.T) {
+	body := releaseStreamsBody(20, 50)
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	gz.Write(body)
+	gz.Close()
+
+	tests := []struct {
+		name     string
+		gzip     bool
+		maxBytes int64
+		tooLarge bool
+	}{
+		{name: "larger than the limit", maxBytes: int64(len(body)) - 1, tooLarge: true},
+		{name: "decompressed larger than the limit", gzip: true, maxBytes: int64(len(body)) - 1, tooLarge: true},
+		{name: "at the limit", maxBytes: int64(len(body))},
+		{name: "decompressed at the limit", gzip: true, maxBytes: int64(len(body))},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+					w.Write(compressed.Bytes())
+					return
+				}
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			releases, _, _, err := getReleaseStream(context.Background(), srv.Client(), srv.URL+allReleasePath, "", tc.maxBytes)
+			if !tc.tooLarge {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(releases) != 20 || len(releases["4.19.0-0.nightly"]) != 50 {
+					t.Errorf("expected 20 streams of 50 payloads, got %d streams", len(releases))
+				}
+				return
+			}
+			var fetchErr *FetchError
+			if !errors.As(err, &fetchErr) || fetchErr.Kind != FetchErrorDecode || !errors.Is(err, errResponseTooLarge) {
+				t.Fatalf("expected a decode error for a too large response, got %v", err)
+			}
+			if releases != nil {
+				t.Errorf("expected no streams from a too large response, got %d", len(releases))
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeReleaseStreams(b *testing.B) {
+	// about as many streams and payloads as the all releases response of a busy release controller
+	body := releaseStreamsBody(200, 500)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeReleaseStreams(bytes.NewReader(body)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// upgradeFixture returns the payloads of nightly and ci streams for each of the minors, newest first, and an upgrade
+// graph with patch and minor upgrades to each of them.
+func upgradeFixture(minors, payloads int, now time.Time) (map[string][]string, []string, GraphMap) {
+	releases := map[string][]string{}
+	streams := []string{}
+	graph := GraphMap{}
+	for minor := 1; minor <= minors; minor++ {
+		for _, streamType := range []string{"nightly", "ci"} {
+			stream := fmt.Sprintf("4.%d.0-0.%s", minor, streamType)
+			streams = append(streams, stream)
+			for p := 0; p < payloads; p++ {
+				// the payload names are in EST
+				built := now.Add(-time.Duration(p) * 30 * time.Minute).In(time.FixedZone("EST", -5*60*60))
+				payload := fmt.Sprintf("%s-%s", stream, built.Format("2006-01-02-150405"))
+				releases[stream] = append(releases[stream], payload)
+				// only some payloads are upgraded to, so the streams' findings differ
+				if p%3 == minor%3 {
+					graph[payload] = []string{fmt.Sprintf("4.%d.%d", minor, p%5), fmt.Sprintf("4.%d.%d", minor-1, p%7)}
+				}
+			}
+		}
+	}
+	return releases, streams, graph
+}
+
+func TestCheckUpgradesParallel(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	releases, streams, graph := upgradeFixture(30, 40, now)
+	clock := newStalenessClock(&Config{}, nil)
+	serial, err := checkUpgrades(context.Background(), DefaultVersionScheme, graph, releases, streams, 12*time.Hour, clock, now, true, false, 0, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(serial) != len(streams) {
+		t.Fatalf("expected a result for each of the %d streams, got %d", len(streams), len(serial))
+	}
+	missing := 0
+	for _, r := range serial {
+		if len(r.Findings) > 0 {
+			missing++
+		}
+	}
+	if missing == 0 || missing == len(serial) {
+		t.Fatalf("expected only some of the streams to be missing upgrades, %d of %d are", missing, len(serial))
+	}
+	for _, workers := range []int{2, 8, 64} {
+		parallel, err := checkUpgrades(context.Background(), DefaultVersionScheme, graph, releases, streams, 12*time.Hour, clock, now, true, false, 0, workers)
+		if err != nil {
+			t.Fatalf("unexpected error with %d workers: %v", workers, err)
+		}
+		if !reflect.DeepEqual(parallel, serial) {
+			t.Errorf("expected the results with %d workers to equal the serial results", workers)
+		}
+	}
+}
+
+func BenchmarkCheckUpgrades(b *testing.B) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	releases, streams, graph := upgradeFixture(100, 200, now)
+	clock := newStalenessClock(&Config{}, nil)
+	for _, workers := range []int{1, DefaultMaxConcurrency} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := checkUpgrades(context.Background(), DefaultVersionScheme, graph, releases, streams, 72*time.Hour, clock, now, true, false, 0, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
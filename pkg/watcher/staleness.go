@@ -0,0 +1,85 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stalenessLimit is how old a stream's newest payload can be before the stream is considered stale,
+// optionally varying by the stream's minor version.
+type stalenessLimit struct {
+	defaultLimit time.Duration
+	// byMinor holds limits which apply to streams of the minor version and every newer minor, up to the
+	// next minor with its own limit.  Streams older than every minor in the map use the default limit.
+	byMinor map[int]time.Duration
+	// scheme extracts the minor versions of streams, it's only needed when byMinor is set
+	scheme *VersionScheme
+}
+
+// forStream returns the limit which applies to the stream.
+func (l *stalenessLimit) forStream(stream string) time.Duration {
+	if len(l.byMinor) == 0 {
+		return l.defaultLimit
+	}
+	minor := l.scheme.StreamMinor(stream)
+	limit := l.defaultLimit
+	closest := -1
+	for m, d := range l.byMinor {
+		if m <= minor && m > closest {
+			closest = m
+			limit = d
+		}
+	}
+	return limit
+}
+
+// MinorDurations is a pflag.Value for a list of minor version durations, e.g. "4.16:24h,4.12:168h".
+type MinorDurations map[int]time.Duration
+
+func (v *MinorDurations) String() string {
+	minors := []int{}
+	for m := range *v {
+		minors = append(minors, m)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(minors)))
+	entries := []string{}
+	for _, m := range minors {
+		// like Set, this doesn't know the major version the flags select, so it assumes the default
+		entries = append(entries, fmt.Sprintf("%s:%s", DefaultVersionScheme.FormatMinor(m), (*v)[m]))
+	}
+	return strings.Join(entries, ",")
+}
+
+func (v *MinorDurations) Set(value string) error {
+	if *v == nil {
+		*v = make(MinorDurations)
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected MAJOR.MINOR:DURATION, got %q", entry)
+		}
+		// the major version isn't checked, since the flags setting it may not have been parsed yet
+		version := strings.Split(parts[0], ".")
+		if _, err := strconv.Atoi(version[0]); len(version) != 2 || err != nil {
+			return fmt.Errorf("expected a MAJOR.MINOR version, got %q", parts[0])
+		}
+		minor, err := strconv.Atoi(version[1])
+		if err != nil || minor < 0 {
+			return fmt.Errorf("expected an integer minor version in %q", parts[0])
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid duration in %q: %v", entry, err)
+		}
+		(*v)[minor] = d
+	}
+	return nil
+}
+
+func (v *MinorDurations) Type() string {
+	return "minorDurations"
+}
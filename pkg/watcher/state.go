@@ -0,0 +1,369 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// reportState is the subset of a report which is persisted between runs so the next run can
+// determine what changed.
+type reportState struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Timestamp     time.Time `json:"timestamp"`
+	// Streams holds the unhealthy findings for every stream in the report, keyed by stream name.
+	// Healthy streams have no findings.
+	Streams map[string][]string `json:"streams"`
+	// Alerts records when each unhealthy stream was last included in a report, keyed by stream name.
+	Alerts map[string]alertState `json:"alerts,omitempty"`
+	// NotAccepting lists the streams which had no recently accepted payloads.  It is nil in state files
+	// written before it was recorded.
+	NotAccepting []string `json:"notAccepting"`
+	// StaleRuns counts how many consecutive runs each stream has had each kind of staleness finding, keyed by
+	// stream name and then finding kind.
+	StaleRuns map[string]map[FindingKind]int `json:"staleRuns,omitempty"`
+	// NewestPayloads records when each stream's newest built payload was built, and for how many runs it has been
+	// the newest, keyed by stream name.
+	NewestPayloads map[string]newestPayloadState `json:"newestPayloads,omitempty"`
+	// Incidents are the open incidents recorded by SaveIncidents, keyed by stream name.
+	Incidents map[string]string `json:"incidents,omitempty"`
+}
+
+// newestPayloadState is a stream's newest built payload, and how long it has been unchanged.
+type newestPayloadState struct {
+	Built time.Time `json:"built"`
+	// Since is the time of the first run the payload was the newest
+	Since time.Time `json:"since"`
+	// UnchangedRuns counts the consecutive runs which found the same newest payload, including the first one, so it
+	// is 1 on the run which first finds it
+	UnchangedRuns int `json:"unchangedRuns"`
+}
+
+// alertState is the state of an unhealthy stream the last time it was included in a report.
+type alertState struct {
+	Time     time.Time `json:"time"`
+	Findings int       `json:"findings"`
+}
+
+// ReportDiff describes how the health of the streams changed between two report runs.
+type ReportDiff struct {
+	since          time.Time
+	newlyUnhealthy []string
+	recovered      []string
+	// recoveredFrom are the findings each recovered stream had in the previous run
+	recoveredFrom  map[string][]string
+	stillUnhealthy []string
+	// stoppedAccepting are the streams which had recently accepted payloads in the previous run, but no longer do
+	stoppedAccepting []string
+}
+
+func (rep *Report) state(now time.Time) *reportState {
+	state := &reportState{
+		SchemaVersion: schemaVersion,
+		Timestamp:     now,
+		Streams:       make(map[string][]string, len(rep.Streams)),
+		Alerts:        make(map[string]alertState),
+		NotAccepting:  []string{},
+		StaleRuns:     make(map[string]map[FindingKind]int),
+	}
+	for stream, r := range rep.Streams {
+		state.Streams[stream] = []string{}
+		for _, f := range r.Findings {
+			// informational findings don't make the stream unhealthy
+			if f.Severity == SeverityInfo {
+				continue
+			}
+			state.Streams[stream] = append(state.Streams[stream], f.Message)
+		}
+		if r.notAccepting() {
+			state.NotAccepting = append(state.NotAccepting, stream)
+		}
+	}
+	sort.Strings(state.NotAccepting)
+	return state
+}
+
+// loadState reads the state persisted by a previous run.  A missing state file is not an error, it
+// just means there is no previous run to compare against, so nil is returned.
+func loadState(path string) (*reportState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %v", path, err)
+	}
+	state := &reportState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error decoding state file %s: %v", path, err)
+	}
+	if state.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("state file %s has schema version %d, but only versions up to %d are supported", path, state.SchemaVersion, schemaVersion)
+	}
+	return state, nil
+}
+
+// saveState persists the state, replacing the file atomically so a crash can't leave a partial file behind.
+func saveState(path string, state *reportState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error writing state file %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state file %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing state file %s: %v", path, err)
+	}
+	return nil
+}
+
+// diffReports compares the current report against the state of the previous run.  Streams which
+// were not part of the previous run (e.g. a newly created stream) are treated as previously healthy.
+func diffReports(prev *reportState, cur *Report) *ReportDiff {
+	diff := &ReportDiff{since: prev.Timestamp, recoveredFrom: map[string][]string{}}
+	prevNotAccepting := make(map[string]bool, len(prev.NotAccepting))
+	for _, stream := range prev.NotAccepting {
+		prevNotAccepting[stream] = true
+	}
+	for stream, r := range cur.Streams {
+		// only streams known to have been accepting payloads in the previous run can have stopped
+		if _, known := prev.Streams[stream]; known && prev.NotAccepting != nil && !prevNotAccepting[stream] && r.notAccepting() {
+			diff.stoppedAccepting = append(diff.stoppedAccepting, stream)
+		}
+		wasUnhealthy := len(prev.Streams[stream]) > 0
+		isUnhealthy := r.Unhealthy()
+		switch {
+		case isUnhealthy && wasUnhealthy:
+			diff.stillUnhealthy = append(diff.stillUnhealthy, stream)
+		case isUnhealthy:
+			diff.newlyUnhealthy = append(diff.newlyUnhealthy, stream)
+		case wasUnhealthy:
+			diff.recovered = append(diff.recovered, stream)
+			diff.recoveredFrom[stream] = prev.Streams[stream]
+		}
+	}
+	sort.Strings(diff.newlyUnhealthy)
+	sort.Strings(diff.recovered)
+	sort.Strings(diff.stillUnhealthy)
+	sort.Strings(diff.stoppedAccepting)
+	return diff
+}
+
+// Changed returns true if any stream's health changed since the previous run.
+func (d *ReportDiff) Changed() bool {
+	return len(d.newlyUnhealthy) > 0 || len(d.recovered) > 0 || len(d.stoppedAccepting) > 0
+}
+
+// stoppedAcceptingString lists the streams which stopped accepting payloads since the previous run, the
+// most actionable change since it is a regression rather than a chronic problem.
+func (d *ReportDiff) stoppedAcceptingString() string {
+	if len(d.stoppedAccepting) == 0 {
+		return ""
+	}
+	output := "Newly stopped accepting payloads:\n"
+	for _, stream := range d.stoppedAccepting {
+		output += fmt.Sprintf("  * %s\n", stream)
+	}
+	return output
+}
+
+// RecoveredString acknowledges the streams which had findings in the previous run but are healthy now, with the
+// findings they recovered from, so an alert is followed up rather than just going quiet.  It returns an empty
+// string if no stream recovered.
+func (d *ReportDiff) RecoveredString() string {
+	if len(d.recovered) == 0 {
+		return ""
+	}
+	output := fmt.Sprintf("RECOVERED since the last run (%s):\n", d.since.UTC().Format(time.RFC3339))
+	for _, stream := range d.recovered {
+		output += fmt.Sprintf("  * %s is healthy again, it had: %s\n", stream, strings.Join(d.recoveredFrom[stream], "; "))
+	}
+	return output
+}
+
+func (d *ReportDiff) String() string {
+	output := d.stoppedAcceptingString()
+	if output != "" {
+		output += "\n"
+	}
+	output += fmt.Sprintf("Changes since last run (%s):\n", d.since.UTC().Format(time.RFC3339))
+	if len(d.newlyUnhealthy) == 0 && len(d.recovered) == 0 {
+		output += "  * No streams changed health\n"
+	}
+	for _, stream := range d.newlyUnhealthy {
+		output += fmt.Sprintf("  * Newly unhealthy: %s\n", stream)
+	}
+	for _, stream := range d.recovered {
+		output += fmt.Sprintf("  * Recovered: %s\n", stream)
+	}
+	if len(d.stillUnhealthy) > 0 {
+		output += fmt.Sprintf("  * Still unhealthy: %s\n", strings.Join(d.stillUnhealthy, ", "))
+	}
+	return output
+}
+
+// ApplyState compares the report against the previous run recorded in the state file, if any, and
+// then records the report as the new previous run.  Unhealthy streams already reported within the
+// realert interval are marked as suppressed, an interval of 0 never suppresses them.
+func (rep *Report) ApplyState(stateFile string, realertInterval time.Duration) error {
+	if stateFile == "" {
+		return nil
+	}
+	prev, err := loadState(stateFile)
+	if err != nil {
+		return err
+	}
+	now := clockOrReal(rep.clock).Now()
+	// frozen streams are reported as findings, so they have to be detected before the state is recorded
+	newest := rep.detectFrozenPayloads(prev, now)
+	cur := rep.state(now)
+	cur.NewestPayloads = newest
+	if prev != nil {
+		rep.Diff = diffReports(prev, rep)
+		// the incidents are only changed by SaveIncidents
+		cur.Incidents = prev.Incidents
+	}
+	rep.countStaleRuns(prev, cur)
+	rep.suppressRepeatAlerts(prev, cur, now, realertInterval)
+	return saveState(stateFile, cur)
+}
+
+// LoadIncidents returns the open incidents recorded in the state file by SaveIncidents, keyed by stream name, or
+// nil if none are.
+func LoadIncidents(stateFile string) (map[string]string, error) {
+	state, err := loadState(stateFile)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	return state.Incidents, nil
+}
+
+// SaveIncidents records the incidents open for unhealthy streams, e.g. in PagerDuty, keyed by stream name with the
+// value identifying the incident, so a later run only resolves the incidents it opened.  They are added to the run
+// recorded by ApplyState.
+func SaveIncidents(stateFile string, incidents map[string]string) error {
+	state, err := loadState(stateFile)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("state file %s has no run recorded by ApplyState to record the incidents of", stateFile)
+	}
+	state.Incidents = incidents
+	return saveState(stateFile, state)
+}
+
+// Compare records the changes since the previous report as the report's Diff, as ApplyState does for the run
+// recorded in the state file, e.g. to compare reports generated by the same process.
+func (rep *Report) Compare(prev *Report) {
+	since := prev.generatedAt
+	if since.IsZero() {
+		since = clockOrReal(prev.clock).Now()
+	}
+	rep.Diff = diffReports(prev.state(since), rep)
+}
+
+// countStaleRuns records in cur how many consecutive runs each stream has had each of its staleness findings,
+// and adds the count to the findings seen for more than one run, to tell a sustained outage from a blip.  A
+// stream which is no longer stale starts counting again from one.
+func (rep *Report) countStaleRuns(prev, cur *reportState) {
+	for stream, r := range rep.Streams {
+		for i := range r.Findings {
+			f := &r.Findings[i]
+			if f.Kind != FindingAcceptedStale && f.Kind != FindingBuiltStale {
+				continue
+			}
+			runs := 1
+			if prev != nil {
+				runs += prev.StaleRuns[stream][f.Kind]
+			}
+			if cur.StaleRuns[stream] == nil {
+				cur.StaleRuns[stream] = make(map[FindingKind]int)
+			}
+			cur.StaleRuns[stream][f.Kind] = runs
+			f.StaleRuns = runs
+			if runs > 1 {
+				f.Message += fmt.Sprintf(" (stale for %d consecutive runs)", runs)
+			}
+		}
+	}
+}
+
+// detectFrozenPayloads returns the newest built payload of each stream, and reports the streams whose newest payload
+// has been the same for FrozenPayloadRuns consecutive runs, counting the run which first found it.  A stalled
+// release controller keeps reporting the same payloads, so this catches it before they are old enough to be stale.
+// Streams already reported as not building payloads recently aren't reported again.
+func (rep *Report) detectFrozenPayloads(prev *reportState, now time.Time) map[string]newestPayloadState {
+	newest := make(map[string]newestPayloadState, len(rep.newestPayloads))
+	for stream, payloads := range rep.newestPayloads {
+		if payloads.built.IsZero() {
+			continue
+		}
+		state := newestPayloadState{Built: payloads.built, Since: now, UnchangedRuns: 1}
+		if prev != nil {
+			if last, ok := prev.NewestPayloads[stream]; ok && last.Built.Equal(payloads.built) {
+				state.Since = last.Since
+				state.UnchangedRuns = last.UnchangedRuns + 1
+			}
+		}
+		newest[stream] = state
+
+		r, ok := rep.Streams[stream]
+		if !ok || rep.frozenPayloadRuns <= 0 || state.UnchangedRuns < rep.frozenPayloadRuns {
+			continue
+		}
+		building := true
+		for _, f := range r.Findings {
+			if f.Kind == FindingBuiltStale || f.Kind == FindingNoBuilt {
+				building = false
+			}
+		}
+		if !building {
+			continue
+		}
+		severity, message := SeverityWarning, fmt.Sprintf("Newest payload, built at %s, has not changed for %d runs since %s, the release controller may be stalled", payloads.built.UTC().Format(time.RFC3339), state.UnchangedRuns, state.Since.UTC().Format(time.RFC3339))
+		if rep.freeze != nil {
+			severity, message = SeverityInfo, message+rep.freezeNote()
+		}
+		r.addFinding(FindingFrozenPayloads, severity, now.Sub(payloads.built), message)
+	}
+	return newest
+}
+
+// suppressRepeatAlerts marks unhealthy streams which were already reported by a previous run as
+// suppressed, unless they have more findings than when they were last reported or the re-alert
+// interval has elapsed.  The alert state for each unhealthy stream is recorded in cur.
+func (rep *Report) suppressRepeatAlerts(prev, cur *reportState, now time.Time, realertInterval time.Duration) {
+	for stream, r := range rep.Streams {
+		if !r.Unhealthy() {
+			continue
+		}
+		if prev != nil && realertInterval > 0 {
+			// newly unhealthy streams never have a previous alert, so they are always reported.
+			if last, ok := prev.Alerts[stream]; ok && len(r.Findings) <= last.Findings && now.Sub(last.Time) < realertInterval {
+				klog.V(4).Infof("Suppressing alert for stream %s, last reported at %s\n", stream, last.Time)
+				r.Suppressed = true
+				cur.Alerts[stream] = last
+				continue
+			}
+		}
+		cur.Alerts[stream] = alertState{Time: now, Findings: len(r.Findings)}
+	}
+}
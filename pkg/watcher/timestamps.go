@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// TimestampName ages payloads by the build time embedded in their name
+	TimestampName = "name"
+	// TimestampAccepted ages accepted payloads by when they were accepted, for release APIs which give the
+	// acceptance time as the acceptedAt field of payload objects.  Payloads without one fall back to their name.
+	TimestampAccepted = "accepted"
+)
+
+// TimestampSources are the times which accepted payloads can be aged by, see Config.TimestampSource.
+var TimestampSources = []string{TimestampName, TimestampAccepted}
+
+// ValidateTimestampSource returns an error if the source is not one of the supported TimestampSources.  Empty
+// means TimestampName.
+func ValidateTimestampSource(source string) error {
+	if source == "" {
+		return nil
+	}
+	for _, s := range TimestampSources {
+		if source == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown timestamp source %q, must be one of %v", source, TimestampSources)
+}
+
+// payloadTime returns the time the payload is aged by, which is its time in times if it has one, e.g. when it was
+// accepted, or else the build time in its name.
+func payloadTime(payload string, times map[string]time.Time) (time.Time, error) {
+	if ts, ok := times[payload]; ok {
+		return ts, nil
+	}
+	return getPayloadTimestamp(payload)
+}
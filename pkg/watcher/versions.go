@@ -0,0 +1,129 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultMajorVersion is the major version of the releases checked unless Config.VersionScheme is set.
+	DefaultMajorVersion = 4
+)
+
+var (
+	// DefaultStreamTypes are the z-stream types checked unless Config.VersionScheme is set.
+	DefaultStreamTypes = []string{"ci", "nightly"}
+
+	// DefaultVersionScheme checks the 4.N.0-0.ci and 4.N.0-0.nightly streams.
+	DefaultVersionScheme = mustVersionScheme(DefaultMajorVersion, DefaultStreamTypes)
+)
+
+// VersionScheme is the major version, and the types of z-stream (e.g. "ci" and "nightly", matching streams
+// like 4.N.0-0.nightly), of the releases which are checked.  Other streams are ignored.  Create one with
+// NewVersionScheme.
+type VersionScheme struct {
+	Major       int
+	StreamTypes []string
+
+	// zRelease matches the checked z-streams, e.g. 4.NNN.0-0.nightly, capturing the minor version and type
+	zRelease *regexp.Regexp
+	// extractMinor matches a MAJOR.MINOR.PATCH version, capturing the minor version
+	extractMinor *regexp.Regexp
+}
+
+// NewVersionScheme returns the scheme checking the z-streams of the types of the major version.
+func NewVersionScheme(major int, streamTypes []string) (*VersionScheme, error) {
+	if major < 1 {
+		return nil, fmt.Errorf("invalid major version %d, must be positive", major)
+	}
+	if len(streamTypes) == 0 {
+		return nil, fmt.Errorf("at least one stream type must be given")
+	}
+	types := []string{}
+	for _, t := range streamTypes {
+		if t == "" {
+			return nil, fmt.Errorf("stream types must not be empty")
+		}
+		types = append(types, regexp.QuoteMeta(t))
+	}
+	// the regex isn't anchored, so try longer types first in case one type is a prefix of another, e.g. okd
+	// and okd-scos
+	sort.SliceStable(types, func(i, j int) bool { return len(types[i]) > len(types[j]) })
+
+	return &VersionScheme{
+		Major:        major,
+		StreamTypes:  append([]string{}, streamTypes...),
+		zRelease:     regexp.MustCompile(fmt.Sprintf(`%d\.(0|[1-9][0-9]*)\.0-0\.(%s)`, major, strings.Join(types, "|"))),
+		extractMinor: regexp.MustCompile(fmt.Sprintf(`%d\.(0|[1-9][0-9]*)\.[0-9]+`, major)),
+	}, nil
+}
+
+func mustVersionScheme(major int, streamTypes []string) *VersionScheme {
+	s, err := NewVersionScheme(major, streamTypes)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// FormatMinor returns the MAJOR.MINOR version of a minor version of the scheme's major version, e.g. "4.16".
+func (s *VersionScheme) FormatMinor(minor int) string {
+	return fmt.Sprintf("%d.%d", s.Major, minor)
+}
+
+// StreamMinor returns the minor version of a z-stream, or -1 if the stream is not a z-stream.
+func (s *VersionScheme) StreamMinor(stream string) int {
+	matches := s.zRelease.FindStringSubmatch(stream)
+	if matches == nil {
+		return -1
+	}
+	v, _ := strconv.Atoi(matches[1])
+	return v
+}
+
+// streamType returns the type of a z-stream, e.g. "nightly", or "" if the stream is not a z-stream.
+func (s *VersionScheme) streamType(stream string) string {
+	matches := s.zRelease.FindStringSubmatch(stream)
+	if matches == nil {
+		return ""
+	}
+	return matches[2]
+}
+
+// versionMinor returns the minor version of a version in the upgrade graph, or -1 if it can't be determined.
+func (s *VersionScheme) versionMinor(version string) int {
+	matches := s.extractMinor.FindStringSubmatch(version)
+	if matches == nil {
+		return -1
+	}
+	v, _ := strconv.Atoi(matches[1])
+	return v
+}
+
+// ValidateMinorRange returns an error if the range of minor versions to report on can't include any release.
+func (s *VersionScheme) ValidateMinorRange(oldestMinor, newestMinor int) error {
+	if oldestMinor < 0 || newestMinor < 0 || newestMinor < oldestMinor {
+		return fmt.Errorf("invalid release range (%s -> %s), release versions must be non-negative and newest must not be older than oldest", s.FormatMinor(oldestMinor), s.FormatMinor(newestMinor))
+	}
+	return nil
+}
+
+// Scheme returns the Config's VersionScheme.  If it isn't set, it's the DefaultMajorVersion streams of the flavor's
+// StreamTypes, or the DefaultVersionScheme for FlavorOCP or an unknown flavor, which fails when the release API
+// url is looked up.
+func (cfg *Config) Scheme() *VersionScheme {
+	if cfg.VersionScheme != nil {
+		return cfg.VersionScheme
+	}
+	if cfg.Flavor != "" && cfg.Flavor != FlavorOCP {
+		if flavor, err := FlavorByName(cfg.Flavor); err == nil {
+			if scheme, err := NewVersionScheme(DefaultMajorVersion, flavor.StreamTypes); err == nil {
+				return scheme
+			}
+		}
+	}
+	return DefaultVersionScheme
+}
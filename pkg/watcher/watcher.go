@@ -0,0 +1,179 @@
+// Package watcher checks the health of the OpenShift release streams served by a release controller:
+// whether each stream has recently built and accepted payloads, and whether its payloads have recently
+// been upgraded to successfully.
+//
+// GenerateReport is the entrypoint:
+//
+//	rep, err := watcher.GenerateReport(ctx, &watcher.Config{OldestMinor: -1, NewestMinor: -1, Arch: "amd64", ...})
+//	fmt.Println(rep.String(false))
+package watcher
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	acceptedReleasePath = "/api/v1/releasestreams/accepted"
+	allReleasePath      = "/api/v1/releasestreams/all"
+
+	// the release controller serves the upgrade graph in the same {nodes, edges} format as Cincinnati
+	defaultGraphPath         = "/graph"
+	defaultGraphChannelParam = "channel"
+	defaultGraphChannel      = "stable"
+
+	// DefaultMaxResponseBytes is the largest (decompressed) response accepted from the release API when
+	// Config.MaxResponseBytes is not set.
+	DefaultMaxResponseBytes = 256 << 20
+
+	// DefaultMaxConcurrency bounds how many requests or checks a report runs concurrently when
+	// Config.MaxConcurrency is not set.
+	DefaultMaxConcurrency = 8
+
+	// LifeCycleUrl is the product life-cycle API used to look up the supported releases.
+	LifeCycleUrl = "https://access.redhat.com/product-life-cycles/api/v1/products?name=Openshift%20Container%20Platform%204"
+)
+
+var (
+	// YYYY-MM-DD-HHMMSS
+	extractDateRegex = regexp.MustCompile(`([0-9]{4})-([0-9]{2})-([0-9]{2})-([0-9]{2})([0-9]{2})([0-9]{2})$`)
+
+	// ReleaseAPIUrls are the release controllers for each architecture of the FlavorOCP releases.
+	ReleaseAPIUrls = map[string]string{
+		"amd64":   "https://amd64.ocp.releases.ci.openshift.org",
+		"arm64":   "https://arm64.ocp.releases.ci.openshift.org",
+		"multi":   "https://multi.ocp.releases.ci.openshift.org",
+		"ppc64le": "https://ppc64le.ocp.releases.ci.openshift.org",
+		"s390x":   "https://s390x.ocp.releases.ci.openshift.org",
+	}
+)
+
+// Config controls which streams GenerateReport checks, and how.
+type Config struct {
+	// OldestMinor and NewestMinor bound (inclusively) the minor versions of the streams to check.  -1 looks
+	// the bound up from the supported releases.
+	OldestMinor int
+	NewestMinor int
+	// Flavor is the distribution to check, one of the Flavors.  Empty checks FlavorOCP.
+	Flavor string
+	// Arch is the architecture to check, one of the keys of the flavor's ReleaseAPIUrls.
+	Arch string
+	// ReleaseAPIUrl, if set, is the release controller to check instead of the one for Arch.
+	ReleaseAPIUrl string
+	// APIToken, if set, is sent as a bearer token with the requests to the release API and the upgrade graph, e.g.
+	// for a release controller behind authentication.  It is only sent to the release API's host, never to a host
+	// it redirects to, nor with other requests such as for the life-cycle data.
+	APIToken string
+	// HTTPClient makes the requests to the release API and the upgrade graph.  nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// VersionScheme is the major version and the types of the z-streams to check.  nil checks the
+	// DefaultMajorVersion streams of the flavor's StreamTypes.
+	VersionScheme *VersionScheme
+	// IncludeStreams, if set, limits the check to streams matching one of the glob patterns.
+	IncludeStreams []string
+	// ExcludeStreams skips the streams matching any of the glob patterns.
+	ExcludeStreams []string
+
+	// AcceptedStalenessLimit is how old a stream's newest accepted payload can be before it is stale.
+	AcceptedStalenessLimit time.Duration
+	// BuiltStalenessLimit is how old a stream's newest built payload can be before it is stale.
+	BuiltStalenessLimit time.Duration
+	// BuiltStalenessByMinor overrides BuiltStalenessLimit for a minor version and every newer minor, up to the
+	// next minor with its own limit.
+	BuiltStalenessByMinor MinorDurations
+	// CriticalStalenessLimit is how old a stream's newest built payload can be before its staleness is critical
+	// rather than a warning, e.g. a stream which hasn't built for weeks.  0 never escalates it.
+	CriticalStalenessLimit time.Duration
+	// TimestampSource is the time accepted payloads are aged by, one of TimestampSources.  Empty ages them by the
+	// build time in their name.
+	TimestampSource string
+	// UpgradeStalenessLimit is how old a successful upgrade to a stream can be before it no longer counts.
+	UpgradeStalenessLimit time.Duration
+	// MaxPayloadsPerStream, if set, only checks the upgrades to that many of each stream's newest payloads, to bound
+	// the work for streams with hundreds of payloads.  Set too low, it can miss the upgrades to older payloads which
+	// are still recent enough to count.
+	MaxPayloadsPerStream int
+	// Checks, if set, limits the analyses run to those listed, from CheckNames and ExtraChecks.  By default all are
+	// run.
+	Checks []string
+	// ExtraChecks are run after the registered checks, except that one named like a registered check replaces it.
+	ExtraChecks []Check
+	// NoUpgradeCheck skips fetching the upgrade graph and checking upgrades, for cheap reports on only whether the
+	// streams built and accepted payloads recently.  Registered checks are given no Graph.
+	NoUpgradeCheck bool
+	// CheckEUSUpgrades also checks even minor streams for upgrades from the previous EUS minor (n-2).
+	CheckEUSUpgrades bool
+	// MinPayloads is how many payloads a stream needs before it is checked for staleness.
+	MinPayloads int
+	// FrozenPayloadRuns is how many consecutive runs recorded in the state file, including the run which first found
+	// it, a stream's newest payload must be the same for the stream to be reported as frozen, which catches a stalled
+	// release controller before the payloads become stale.  0 disables the check.
+	FrozenPayloadRuns int
+	// BusinessDaysOnly excludes weekends and Holidays (YYYY-MM-DD dates) from payload and upgrade ages when
+	// they are compared against the staleness limits.
+	BusinessDaysOnly bool
+	Holidays         []string
+	// CalendarFile, if set, lists non-working YYYY-MM-DD dates and "freeze START END" release freezes, which are
+	// excluded from ages whether or not BusinessDaysOnly is set.  Stale payloads during a freeze are reported as
+	// informational.  The file is read by every report.
+	CalendarFile string
+
+	// Clock is used to tell the time the report is generated at.  nil uses the RealClock.
+	Clock Clock
+	// MaintenanceFile, if set, lists the streams under maintenance, whose findings are not reported as problems.
+	// Each line holds a stream name or glob pattern, optionally followed by the RFC3339 time the maintenance
+	// ends.  The file is read by every report, so edits and expired entries take effect without a restart.
+	MaintenanceFile string
+
+	// AsOf, if set, generates the report as it would have been at that time: payloads built after it are ignored,
+	// and ages are measured up to it rather than to now.  Whether a payload had been accepted by then is not
+	// known, so a payload accepted after AsOf still counts as accepted.
+	AsOf time.Time
+
+	// AbsoluteDates follows the ages in findings and passed checks with the UTC time they were at.
+	AbsoluteDates bool
+	// WithHints adds the first step to investigate each finding to the report, e.g. which jobs to look at.
+	WithHints bool
+	// SortBy is the order the report lists the streams in, one of SortOrders.  Empty sorts by version.
+	SortBy string
+
+	// ShowRates reports how many payloads each stream accepted within RateWindow.
+	ShowRates  bool
+	RateWindow time.Duration
+	// Since, if set, reports how many payloads each stream built and accepted within it.
+	Since time.Duration
+	// ShowHistory reports how many payloads each stream has, and the range of time they cover.
+	ShowHistory bool
+	// ShowPromotionLatency reports the median time each stream's payloads took until they, or a newer payload,
+	// were accepted.
+	ShowPromotionLatency bool
+	// WithJobLinks links each missing upgrade to the most recent failed job attempting it, which takes a release
+	// info request for each of the recent payloads of the streams missing upgrades.
+	WithJobLinks bool
+	// GraphSummary reports the number of edges in the upgrade graph, and how many upgrade to each minor version.
+	GraphSummary bool
+
+	// GraphPath is the path of the upgrade graph relative to the release controller, which is queried with the
+	// GraphChannel in the GraphChannelParam query parameter.  Empty values default to the release controller's
+	// "/graph?channel=stable".  GraphAccept, if set, is sent as the Accept header of the request, e.g.
+	// "application/vnd.redhat.cincinnati.v1+json" for a Cincinnati server.
+	GraphPath         string
+	GraphChannelParam string
+	GraphChannel      string
+	GraphAccept       string
+
+	// MaxResponseBytes is the largest (decompressed) response accepted from the release API, larger responses
+	// fail the report.  0 uses DefaultMaxResponseBytes.
+	MaxResponseBytes int64
+
+	// MaxConcurrency bounds how many requests to the release API, or stream checks, are run concurrently.  0 uses
+	// DefaultMaxConcurrency.
+	MaxConcurrency int
+
+	// DumpRawDir, if set, is a directory to save the raw release API responses in.
+	DumpRawDir string
+	// FromDir, if set, is a directory of raw release API responses saved by DumpRawDir, which are analyzed
+	// instead of fetching them from the release API.
+	FromDir string
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket: tokens are added at a fixed rate, up to burst tokens, and each call
+// to wait consumes one.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// newRateLimiter returns a limiter allowing one call per interval on average, with up to burst calls at once.
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until a token is available and consumes it.
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens < 0 {
+		// holding the lock while sleeping keeps waiters in order, each one leaving the bucket empty for the next
+		delay := time.Duration(-l.tokens * float64(l.interval))
+		time.Sleep(delay)
+		l.tokens = 0
+		l.last = now.Add(delay)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+	"k8s.io/klog"
+)
+
+// reportCache holds the reports the bot recently generated, keyed by reportKey, so the Slack reports, scheduled
+// reports and dashboards polling /report.json which ask for the same report share one query of the release API.
+type reportCache struct {
+	lock    sync.Mutex
+	reports map[string]*reportEntry
+}
+
+// reportEntry is a report which has been or is being generated.
+type reportEntry struct {
+	// done is closed once the report has been generated, after which report, err and generated are set
+	done      chan struct{}
+	report    *watcher.Report
+	err       error
+	generated time.Time
+}
+
+var reports reportCache
+
+// get returns the report with the key if it was generated within the ttl, and otherwise generates a new one.
+// Requests made while a report with the key is being generated wait for and share it rather than generating
+// another, until their context is done.  Failed reports aren't kept, so the next request tries again.  The
+// report is shared, so must be copied before it is modified.
+func (c *reportCache) get(ctx context.Context, key string, ttl time.Duration, generate func() (*watcher.Report, error)) (*watcher.Report, error) {
+	c.lock.Lock()
+	if c.reports == nil {
+		c.reports = make(map[string]*reportEntry)
+	}
+	now := time.Now()
+	for k, entry := range c.reports {
+		// reports requested with arbitrary arguments mustn't accumulate
+		if entry.isDone() && now.Sub(entry.generated) >= ttl {
+			delete(c.reports, k)
+		}
+	}
+	entry, ok := c.reports[key]
+	if !ok {
+		entry = &reportEntry{done: make(chan struct{})}
+		c.reports[key] = entry
+		c.lock.Unlock()
+
+		entry.report, entry.err = generate()
+		c.lock.Lock()
+		entry.generated = time.Now()
+		if entry.err != nil && c.reports[key] == entry {
+			delete(c.reports, key)
+		}
+		close(entry.done)
+		c.lock.Unlock()
+		return entry.report, entry.err
+	}
+	c.lock.Unlock()
+
+	if !entry.isDone() {
+		klog.V(4).Infof("Waiting for the identical report already being generated\n")
+	}
+	select {
+	case <-entry.done:
+		return entry.report, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isDone returns whether the report has been generated.
+func (r *reportEntry) isDone() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportKey identifies the report generated with the config among those the bot generates.  The rest of the
+// config is set by the bot's flags, so is the same for every report, and only the arguments of a report request
+// can differ.
+func reportKey(cfg *watcher.Config) string {
+	return fmt.Sprintf("%s|%s|%d|%d|%s|%s", cfg.Arch, cfg.ReleaseAPIUrl, cfg.OldestMinor, cfg.NewestMinor, strings.Join(cfg.IncludeStreams, ","), strings.Join(cfg.ExcludeStreams, ","))
+}
+
+// cachedReport returns the report generated with the options' config, reusing one generated within the report
+// cache ttl.  The report is shared with every request for it, so it isn't cancelled when the request which
+// started it is, and must be copied before it is modified.
+func (o *options) cachedReport(ctx context.Context) (*watcher.Report, error) {
+	return reports.get(ctx, reportKey(&o.Config), o.reportCacheTTL, func() (*watcher.Report, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		defer cancel()
+		return watcher.GenerateReport(ctx, &o.Config)
+	})
+}
+
+// reportJSONHandler serves the report generated with the bot's own options in the JSON format, generating one
+// if there is no report within the cache ttl.  The report's generatedAt field says how fresh it is.
+func (o *options) reportJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	rep, err := o.cachedReport(r.Context())
+	if err != nil {
+		klog.Errorf("error generating the report for %s: %v", r.URL.Path, err)
+		http.Error(w, "error generating the report", http.StatusBadGateway)
+		return
+	}
+	output, err := rep.Format(watcher.FormatJSON, true)
+	if err != nil {
+		klog.Errorf("error formatting the report for %s: %v", r.URL.Path, err)
+		http.Error(w, "error formatting the report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, output+"\n")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+)
+
+func TestReportCacheCoalesces(t *testing.T) {
+	c := &reportCache{}
+	release := make(chan struct{})
+	var generated int32
+	generate := func() (*watcher.Report, error) {
+		atomic.AddInt32(&generated, 1)
+		<-release
+		return &watcher.Report{ReleaseAPIUrl: "https://amd64.ocp.releases.ci.openshift.org"}, nil
+	}
+
+	got := make([]*watcher.Report, 5)
+	wg := sync.WaitGroup{}
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rep, err := c.get(context.Background(), "amd64", time.Minute, generate)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			got[i] = rep
+		}(i)
+	}
+	// give every request the chance to find the one being generated
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&generated); n != 1 {
+		t.Errorf("expected concurrent requests to generate the report once, generated it %d times", n)
+	}
+	for i, rep := range got {
+		if rep != got[0] {
+			t.Errorf("expected request %d to share the generated report", i)
+		}
+	}
+}
+
+func TestReportCacheGet(t *testing.T) {
+	generated := 0
+	generate := func() (*watcher.Report, error) {
+		generated++
+		return &watcher.Report{}, nil
+	}
+	failed := func() (*watcher.Report, error) {
+		generated++
+		return nil, errors.New("release API unavailable")
+	}
+
+	tests := []struct {
+		name string
+		// keys are requested in order, and the error is the last request's
+		keys     []string
+		ttl      time.Duration
+		generate func() (*watcher.Report, error)
+
+		expectedGenerated int
+		expectedErr       bool
+	}{
+		{
+			name:              "reused within the ttl",
+			keys:              []string{"amd64", "amd64"},
+			ttl:               time.Minute,
+			generate:          generate,
+			expectedGenerated: 1,
+		},
+		{
+			name:              "regenerated after the ttl",
+			keys:              []string{"amd64", "amd64"},
+			ttl:               0,
+			generate:          generate,
+			expectedGenerated: 2,
+		},
+		{
+			name:              "generated for each key",
+			keys:              []string{"amd64", "arm64", "amd64"},
+			ttl:               time.Minute,
+			generate:          generate,
+			expectedGenerated: 2,
+		},
+		{
+			name:              "failures aren't kept",
+			keys:              []string{"amd64", "amd64"},
+			ttl:               time.Minute,
+			generate:          failed,
+			expectedGenerated: 2,
+			expectedErr:       true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			generated = 0
+			c := &reportCache{}
+			var err error
+			for _, key := range tc.keys {
+				_, err = c.get(context.Background(), key, tc.ttl, tc.generate)
+			}
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("expected error %t, got %v", tc.expectedErr, err)
+			}
+			if generated != tc.expectedGenerated {
+				t.Errorf("expected the report to be generated %d times, got %d", tc.expectedGenerated, generated)
+			}
+		})
+	}
+}
+
+func TestReportCacheWaitCancelled(t *testing.T) {
+	c := &reportCache{}
+	release := make(chan struct{})
+	defer close(release)
+	go c.get(context.Background(), "amd64", time.Minute, func() (*watcher.Report, error) {
+		<-release
+		return &watcher.Report{}, nil
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.get(ctx, "amd64", time.Minute, func() (*watcher.Report, error) {
+		t.Fatal("expected the report being generated to be waited for")
+		return nil, nil
+	}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestReportKey(t *testing.T) {
+	base := watcher.Config{Arch: "amd64", OldestMinor: 14, NewestMinor: 16, IncludeStreams: []string{"*.nightly"}}
+	tests := []struct {
+		name   string
+		modify func(cfg *watcher.Config)
+		same   bool
+	}{
+		{name: "same request", modify: func(cfg *watcher.Config) {}, same: true},
+		{name: "arch", modify: func(cfg *watcher.Config) { cfg.Arch = "arm64" }},
+		{name: "release API url", modify: func(cfg *watcher.Config) { cfg.ReleaseAPIUrl = "https://staging.example.com" }},
+		{name: "oldest minor", modify: func(cfg *watcher.Config) { cfg.OldestMinor = 15 }},
+		{name: "newest minor", modify: func(cfg *watcher.Config) { cfg.NewestMinor = 15 }},
+		{name: "included streams", modify: func(cfg *watcher.Config) { cfg.IncludeStreams = append(cfg.IncludeStreams, "4.16.*") }},
+		{name: "excluded streams", modify: func(cfg *watcher.Config) { cfg.ExcludeStreams = []string{"4.16.0-0.ci"} }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := base
+			cfg.IncludeStreams = append([]string{}, base.IncludeStreams...)
+			tc.modify(&cfg)
+			if same := reportKey(&base) == reportKey(&cfg); same != tc.same {
+				t.Errorf("expected the keys to be the same %t, got %q and %q", tc.same, reportKey(&base), reportKey(&cfg))
+			}
+		})
+	}
+}
+
+func TestReportJSONHandler(t *testing.T) {
+	var fetches int32
+	api := newTestReleaseController(&fetches)
+	defer api.Close()
+
+	posts := make(chan testPost, 10)
+	o := newTestBot(posts)
+	o.ReleaseAPIUrl = api.URL
+	o.reportCacheTTL = time.Hour
+
+	w := httptest.NewRecorder()
+	o.reportJSONHandler(w, httptest.NewRequest(http.MethodPost, "/report.json", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected a POST to be rejected with status 405, got %d", w.Code)
+	}
+
+	// a report posted to Slack is recorded in the cache the handler serves
+	deliver(t, o.createHandler(context.Background()), Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "<@UE23Q9BFY> report", Channel: "C0123ABCD", TS: "1717416000.000100"}}, nil)
+	waitForPosts(t, posts, 2)
+
+	w = httptest.NewRecorder()
+	o.reportJSONHandler(w, httptest.NewRequest(http.MethodGet, "/report.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected a JSON response, got Content-Type %q", got)
+	}
+	rep := struct {
+		GeneratedAt   string `json:"generatedAt"`
+		ReleaseAPIUrl string `json:"releaseAPIUrl"`
+		Streams       []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"streams"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("error decoding the report %q: %v", w.Body.String(), err)
+	}
+	if rep.GeneratedAt != "2024-06-03T12:00:00Z" {
+		t.Errorf("expected the report to be generated at 2024-06-03T12:00:00Z, got %q", rep.GeneratedAt)
+	}
+	if rep.ReleaseAPIUrl != api.URL {
+		t.Errorf("expected the report on %s, got %s", api.URL, rep.ReleaseAPIUrl)
+	}
+	if len(rep.Streams) != 1 || rep.Streams[0].Name != "4.15.0-0.nightly" || rep.Streams[0].Status != "healthy" {
+		t.Errorf("expected the healthy 4.15.0-0.nightly stream, got %+v", rep.Streams)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the handler to serve the report posted to Slack, the release streams were fetched %d times", n)
+	}
+}
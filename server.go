@@ -1,33 +1,76 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
-	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/bparees/release-watcher/pkg/watcher"
 	"k8s.io/klog"
 )
 
 var (
-	mutex          = &sync.Mutex{}
-	msgCache       = make(map[string]struct{})
-	auth_token     string
+	mutex = &sync.Mutex{}
+	// msgCache records when each message was first delivered, keyed by dedupKey, so redeliveries are ignored.  It
+	// is guarded by mutex.
+	msgCache       = make(map[string]time.Time)
 	patchmanagerId = "SMZ7PJ1L0"
+	// threadOptions are the options of the report requested at the start of each thread, so follow-up requests in
+	// the thread look at the same release controller.  It is guarded by mutex.
+	threadOptions = make(map[string]threadReport)
+	// slackChannelRegex matches Slack channel IDs: public (C), private (G) and direct message (D) channels
+	slackChannelRegex = regexp.MustCompile(`^[CGD][A-Z0-9]{6,}$`)
 )
 
+const (
+	// serverReadTimeout is how long a client has to send the whole request, including the body.
+	serverReadTimeout = 10 * time.Second
+	// eventTimeout bounds the handling of a Slack event, including generating and posting the report,
+	// which runs after the event has been acknowledged
+	eventTimeout = 10 * time.Minute
+	// msgCacheTTL is how long a message is remembered to ignore its redeliveries, well beyond the minutes Slack
+	// retries a delivery for
+	msgCacheTTL = time.Hour
+	// threadOptionsTTL is how long after a report is requested its thread's follow-up requests use its options
+	threadOptionsTTL = 7 * 24 * time.Hour
+)
+
+// threadReport is the options of the report which started a thread, and when it was requested.
+type threadReport struct {
+	options   *options
+	requested time.Time
+}
+
+// pruneCaches removes the messages and threads which are no longer remembered from msgCache and threadOptions, so
+// they don't grow for as long as the bot runs.  mutex must be held.
+func pruneCaches(now time.Time) {
+	for key, seen := range msgCache {
+		if now.Sub(seen) >= msgCacheTTL {
+			delete(msgCache, key)
+		}
+	}
+	for thread, t := range threadOptions {
+		if now.Sub(t.requested) >= threadOptionsTTL {
+			delete(threadOptions, thread)
+		}
+	}
+}
+
 type Request struct {
 	Token string `json:"token"`
 	Type  string `json:"type"`
+	// EventID uniquely identifies an event_callback delivery, and is the same on retries of that delivery
+	EventID string `json:"event_id"`
 
 	// challenge request fields
 	Challenge string `json:"challenge"`
@@ -42,6 +85,11 @@ type Event struct {
 	User    string `json:"user"`
 	Channel string `json:"channel"`
 	TS      string `json:"ts"`
+	// ThreadTS is the ts of the message starting the thread, when the message is a reply in a thread
+	ThreadTS string `json:"thread_ts"`
+	// ClientMsgID identifies the message a message or app_mention event is for, and unlike the ts it is the
+	// same however the message is delivered.  Not every event has one.
+	ClientMsgID string `json:"client_msg_id"`
 }
 
 type VerificationResponse struct {
@@ -56,30 +104,79 @@ type PostMessage struct {
 }
 
 type PostMessageResponse struct {
-	TS string `json:"ts"`
+	OK bool `json:"ok"`
+	// Error is the Slack error code when OK is false, e.g. "channel_not_found"
+	Error string `json:"error"`
+	TS    string `json:"ts"`
 }
 
-func (o *options) serve() {
+func (o *options) serve(ctx context.Context) {
 	rand.Seed(time.Now().UTC().UnixNano())
-	auth_token = os.Getenv("TOKEN")
-	http.HandleFunc("/", o.createHandler())  // set router
-	err := http.ListenAndServe(":8080", nil) // set listen port
-	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", o.createHandler(ctx)) // set router
+	mux.HandleFunc("/readyz", readyz)
+	mux.HandleFunc("/report.json", o.reportJSONHandler)
+	server := &http.Server{
+		Addr:    ":8080", // set listen port
+		Handler: logRequests(mux),
+		// Slack requests are small, so a slow client is just holding the connection open
+		ReadTimeout: serverReadTimeout,
 	}
+	go func() {
+		<-ctx.Done()
+		klog.Info("Shutting down server")
+		server.Shutdown(context.Background())
+	}()
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		klog.Exitf("ListenAndServe: %v", err)
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
 }
 
-func (o *options) createHandler() http.HandlerFunc {
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs the method, path, response status and duration of every request.  Request bodies are
+// deliberately not logged since they contain the contents of Slack messages.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// handlers which never call WriteHeader respond with 200
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		klog.V(2).Infof("%s %s %d %s", r.Method, r.URL.Path, rec.status, time.Since(start))
+	})
+}
+
+// createHandler returns the handler of Slack requests.  Events are acknowledged immediately and handled in
+// the background, since Slack retries an event which isn't acknowledged within 3 seconds and generating a
+// report takes longer than that.  ctx is the lifetime of the server, and cancels any events being handled
+// when it is done.
+func (o *options) createHandler(ctx context.Context) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		body, err := ioutil.ReadAll(r.Body)
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, o.maxRequestBytes))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			klog.Errorf("rejecting request body larger than %d bytes", maxBytesErr.Limit)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil {
-			fmt.Printf("error: %v\n", err)
+			klog.Errorf("error reading request body: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		req := Request{}
 		if err := json.Unmarshal([]byte(body), &req); err != nil {
-			fmt.Printf("error: %v\n", err)
+			klog.Errorf("error decoding request body: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -96,123 +193,316 @@ func (o *options) createHandler() http.HandlerFunc {
 
 		if req.Type == "event_callback" {
 
+			key := req.dedupKey()
+			if retry := r.Header.Get("X-Slack-Retry-Num"); retry != "" {
+				klog.V(2).Infof("Slack retry %s of event %s, reason: %s", retry, key, r.Header.Get("X-Slack-Retry-Reason"))
+			}
+
 			mutex.Lock()
-			if _, found := msgCache[req.Event.TS]; found {
-				klog.V(4).Infof("ignoring dupe event: %#v\n", req.Event)
-				w.WriteHeader(http.StatusOK)
-				mutex.Unlock()
-				return
+			now := time.Now()
+			pruneCaches(now)
+			// an event without an identifier can't be told apart from other events, so is never treated as a dupe
+			if key != "" {
+				if _, found := msgCache[key]; found {
+					// either a retry of a delivery we already accepted, or the same message delivered as a different event
+					klog.V(4).Infof("ignoring dupe event: %#v\n", req.Event)
+					w.WriteHeader(http.StatusOK)
+					mutex.Unlock()
+					return
+				}
+				msgCache[key] = now
 			}
-			msgCache[req.Event.TS] = struct{}{}
 			mutex.Unlock()
 			klog.V(4).Infof("saw message event: %#v\n", req.Event)
+			w.WriteHeader(http.StatusOK)
+
+			go func() {
+				ctx, cancel := context.WithTimeout(ctx, eventTimeout)
+				defer cancel()
+				o.handleEvent(ctx, req.Event)
+			}()
+		}
+	}
+}
 
-			subject := ""
-			msg := ""
-			thread := req.Event.TS
-			switch {
-			case strings.Contains(req.Event.Text, "help"):
-				subject = fmt.Sprintf(`*help* - this help text
-*report* - Generates human reports about which release streams do not have recently built or recently accepted payloads, based on the release info found at https://amd64.ocp.releases.ci.openshift.org/ or the equivalent page for the architecture specified in the request.
-Arguments:
-  *min=X* - only look at z-streams with a minimum version of X, e.g. *min=9*
-  *max=X* - only look at z-streams with a maximum version of X, e.g. *max=12*
-  *arch=X* - look at architecture X, where X is one of [*amd64*, *multi*, *arm64*, *ppc64le*, *s390x*]
-  *healthy* - include healthy z-streams in the report
-  *tag* - tag patch manager with the report output
-Current settings/defaults:
-  Accepted payloads must be newer than *%0.1f* hours
-  Payloads must have been built within the last *%0.1f* hours
-  Default: Included releases are >=*4.%d* and <=*4.%d*
-  Default: Architecture is *%s*
-  Default: Fully healthy z-streams are not included in the report`, o.acceptedStalenessLimit.Hours(), o.builtStalenessLimit.Hours(), o.oldestMinor, o.newestMinor, o.arch)
-			case strings.Contains(req.Event.Text, "report"):
-				reportOptions := *o
-				reportOptions.includeHealthy = false
-				tagPatchManager := false
-
-				args := strings.Split(req.Event.Text, " ")
-				for _, arg := range args {
-					if arg == "tag" {
-						tagPatchManager = true
-					}
-
-					if arg == "healthy" {
-						reportOptions.includeHealthy = true
-					}
-					if strings.Contains(arg, "=") {
-						v := strings.Split(arg, "=")
-						switch v[0] {
-						case "min":
-							i, err := strconv.Atoi(v[1])
-							if err != nil {
-								err = fmt.Errorf("Error parsing min z-stream version value %q: %w", v[1], err)
-								sendMessage(err.Error(), req.Event.Channel, thread)
-								http.Error(w, err.Error(), http.StatusInternalServerError)
-								return
-							}
-							reportOptions.oldestMinor = i
-
-						case "max":
-							i, err := strconv.Atoi(v[1])
-							if err != nil {
-								err = fmt.Errorf("Error parsing max z-stream version value %q: %w", v[1], err)
-								sendMessage(err.Error(), req.Event.Channel, thread)
-								http.Error(w, err.Error(), http.StatusInternalServerError)
-								return
-							}
-							reportOptions.newestMinor = i
-						case "arch":
-							reportOptions.arch = v[1]
-						}
-					}
+// replyThread returns the thread to reply to the message in, the message's own thread if it is a reply, since
+// replies can't start threads of their own.
+func (e Event) replyThread() string {
+	if e.ThreadTS != "" {
+		return e.ThreadTS
+	}
+	return e.TS
+}
 
-				}
+// handleEvent replies to a message event in its thread, with the first command whose keyword the message
+// contains.
+func (o *options) handleEvent(ctx context.Context, event Event) {
+	subject := fmt.Sprintf("Sorry, I couldn't process that request: %s", event.Text)
+	msg := ""
+	for _, c := range o.commands() {
+		if strings.Contains(event.Text, c.keyword) {
+			subject, msg = c.run(ctx, event)
+			break
+		}
+	}
 
-				rep, err := generateReport(reportOptions.acceptedStalenessLimit, reportOptions.builtStalenessLimit, reportOptions.upgradeStalenessLimit, reportOptions.oldestMinor, reportOptions.newestMinor, reportOptions.arch)
-				if err != nil {
-					subject = fmt.Sprintf("Sorry, an error occurred generating the report: %v", err)
-				} else {
-					numUnhealthy := 0
-					for _, stream := range rep.streams {
-						if len(stream.unhealthyMessages) > 0 {
-							numUnhealthy += 1
-						}
-
-					}
-					subject = fmt.Sprintf("Latest payload stream health report thread for `%s`, `v4.%d` to `v4.%d` (%d of %d streams unhealthy)", reportOptions.arch, rep.oldestMinor, rep.newestMinor, numUnhealthy, len(rep.streams))
-					msg = rep.String(reportOptions.includeHealthy)
-				}
-				if tagPatchManager {
-					if reportOptions.includeHealthy {
-						msg = fmt.Sprintf("<!subteam^%s> here is the latest payload health report\n\n%s", patchmanagerId, msg)
-					} else {
-						msg = fmt.Sprintf("<!subteam^%s> here are the currently unhealthy payload streams that need investigation:\n\n%s", patchmanagerId, msg)
-					}
-				}
+	if err := o.postReport(subject, msg, event.Channel, event.replyThread()); err != nil {
+		klog.Errorf("error replying to message %s in %s: %v", event.TS, event.Channel, err)
+	}
+}
 
-			default:
-				subject = fmt.Sprintf("Sorry, I couldn't process that request: %s", req.Event.Text)
-			}
+// parseReportArgs returns a copy of the options modified by the arguments of a report request, and whether
+// the patch manager should be tagged.
+func (o *options) parseReportArgs(text string) (*options, bool, error) {
+	reportOptions := *o
+	reportOptions.includeHealthy = false
+	// only scheduled reports are tracked in the state file or page, since interactive reports can
+	// cover arbitrary ranges which aren't comparable with each other.
+	reportOptions.stateFile = ""
+	reportOptions.notifyRecoveries = false
+	reportOptions.pagerDutyRoutingKey = ""
+	// copy the patterns so appending to them can't modify the bot's own options
+	reportOptions.IncludeStreams = append([]string{}, o.IncludeStreams...)
+	reportOptions.ExcludeStreams = append([]string{}, o.ExcludeStreams...)
+	tagPatchManager := false
 
-			ts, err := sendMessage(subject, req.Event.Channel, thread)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+	args := strings.Split(text, " ")
+	for _, arg := range args {
+		if arg == "tag" {
+			tagPatchManager = true
+		}
+
+		if arg == "healthy" {
+			reportOptions.includeHealthy = true
+		}
+		if strings.HasPrefix(arg, "api=") {
+			// the bot's API token is only meant for its own release controller
+			if o.APIToken != "" {
+				return nil, false, fmt.Errorf("Sorry, I can't report on another release controller, since I authenticate to mine with an API token")
+			}
+			// the url may itself contain "=", and Slack wraps links it recognizes as "<url>" or "<url|text>"
+			api := strings.TrimPrefix(arg, "api=")
+			if strings.HasPrefix(api, "<") && strings.HasSuffix(api, ">") {
+				api = strings.SplitN(strings.Trim(api, "<>"), "|", 2)[0]
+			}
+			if _, err := watcher.NormalizeReleaseAPIUrl(api); err != nil {
+				return nil, false, fmt.Errorf("Sorry, %q isn't a release API url I can report on, it must be an http or https url, e.g. *api=https://amd64.ocp.releases.ci.openshift.org*", api)
 			}
-			if msg != "" {
-				_, err = sendMessage(msg, req.Event.Channel, ts)
+			reportOptions.ReleaseAPIUrl = api
+			continue
+		}
+		if strings.Contains(arg, "=") {
+			v := strings.Split(arg, "=")
+			switch v[0] {
+			case "min":
+				i, err := strconv.Atoi(v[1])
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-				} else {
-					w.WriteHeader(http.StatusOK)
+					return nil, false, fmt.Errorf("Error parsing min z-stream version value %q: %w", v[1], err)
 				}
+				reportOptions.OldestMinor = i
+
+			case "max":
+				i, err := strconv.Atoi(v[1])
+				if err != nil {
+					return nil, false, fmt.Errorf("Error parsing max z-stream version value %q: %w", v[1], err)
+				}
+				reportOptions.NewestMinor = i
+			case "arch":
+				reportOptions.Arch = v[1]
+			case "include":
+				reportOptions.IncludeStreams = append(reportOptions.IncludeStreams, v[1])
+			case "exclude":
+				reportOptions.ExcludeStreams = append(reportOptions.ExcludeStreams, v[1])
 			}
 		}
+
+	}
+	return &reportOptions, tagPatchManager, nil
+}
+
+// dedupKey returns the key identifying the message an event is for, which is the same for every delivery of it.
+func (req *Request) dedupKey() string {
+	// the client_msg_id and event ts identify the message, so also catch the same message arriving as distinct
+	// events (e.g. both a message and an app_mention), but not every event type has them.  The client_msg_id is
+	// assigned by the client which sent the message, so is the more reliable of the two.
+	if req.Event.ClientMsgID != "" {
+		return req.Event.ClientMsgID
+	}
+	if req.Event.TS != "" {
+		return req.Event.TS
+	}
+	return req.EventID
+}
+
+// reportMessages generates a report using the options, or reuses one generated within the report cache ttl,
+// and returns the summary line to post along with the report body to post as a reply to the summary.
+func (o *options) reportMessages(ctx context.Context, tagPatchManager bool) (string, string) {
+	subject := ""
+	msg := ""
+	rep, err := o.cachedReport(ctx)
+	var fetchErr *watcher.FetchError
+	if errors.As(err, &fetchErr) && fetchErr.Transient() {
+		subject = fmt.Sprintf("Sorry, the upstream API serving %s appears to be unavailable, please try again later: %v", fetchErr.What, err)
+	} else if errors.As(err, &fetchErr) && fetchErr.Kind == watcher.FetchErrorDecode {
+		subject = fmt.Sprintf("Sorry, the upstream API returned %s in an unexpected format: %v", fetchErr.What, err)
+	} else if err != nil {
+		subject = fmt.Sprintf("Sorry, an error occurred generating the report: %v", err)
+	} else {
+		// tracking the state and rendering modify the report, which is shared with the other requests for it
+		rep = rep.Copy()
+		subject = fmt.Sprintf("Latest payload stream health report thread for `%s`, `v%s` to `v%s` (%d of %d streams unhealthy)", o.Arch, o.Scheme().FormatMinor(rep.OldestMinor), o.Scheme().FormatMinor(rep.NewestMinor), rep.Unhealthy(), len(rep.Streams))
+		if err := rep.ApplyState(o.stateFile, o.realertInterval); err != nil {
+			klog.Errorf("error tracking report state: %v", err)
+		}
+		if err := o.notifyPagerDuty(rep); err != nil {
+			klog.Errorf("error notifying PagerDuty: %v", err)
+		}
+		if rep.Diff != nil && !rep.Diff.Changed() {
+			// nothing meaningful changed, so avoid the noise of repeating the full report.
+			msg = rep.Diff.String()
+		} else {
+			rep.SlackEmoji = true
+			msg = o.recoveredSection(rep) + rep.String(o.includeHealthy)
+		}
+	}
+	if tagPatchManager {
+		if o.includeHealthy {
+			msg = fmt.Sprintf("<!subteam^%s> here is the latest payload health report\n\n%s", patchmanagerId, msg)
+		} else {
+			msg = fmt.Sprintf("<!subteam^%s> here are the currently unhealthy payload streams that need investigation:\n\n%s", patchmanagerId, msg)
+		}
+	}
+	return subject, msg
+}
+
+// streamDetailsMessages returns the summary line and details of the stream, described with the options of the
+// report which started the thread, if the bot knows it.
+func (o *options) streamDetailsMessages(ctx context.Context, stream, thread string) (string, string) {
+	detailsOptions := o
+	mutex.Lock()
+	if t, ok := threadOptions[thread]; ok && time.Since(t.requested) < threadOptionsTTL {
+		detailsOptions = t.options
+	}
+	mutex.Unlock()
+	if o.Scheme().StreamMinor(stream) == -1 {
+		return fmt.Sprintf("Sorry, %q is not a release stream name, expected something like `4.15.0-0.nightly`", stream), ""
+	}
+	details := &strings.Builder{}
+	if err := watcher.WriteStreamDetails(ctx, details, &detailsOptions.Config, stream); err != nil {
+		return fmt.Sprintf("Sorry, an error occurred looking up the details of %s: %v", stream, err), ""
+	}
+	return fmt.Sprintf("Details of `%s` for `%s`", stream, detailsOptions.Arch), details.String()
+}
+
+// streamStatusMessage generates a report for just the one stream and returns it as a message to post.
+func (o *options) streamStatusMessage(ctx context.Context, stream string) string {
+	minor := o.Scheme().StreamMinor(stream)
+	if minor == -1 {
+		return fmt.Sprintf("Sorry, %q is not a release stream name, expected something like `4.15.0-0.nightly`", stream)
+	}
+	statusOptions := *o
+	// scoping the report to the stream's minor avoids looking up the supported releases
+	statusOptions.OldestMinor = minor
+	statusOptions.NewestMinor = minor
+	statusOptions.IncludeStreams = []string{stream}
+	statusOptions.ExcludeStreams = nil
+	statusOptions.stateFile = ""
+	statusOptions.pagerDutyRoutingKey = ""
+
+	rep, err := watcher.GenerateReport(ctx, &statusOptions.Config)
+	if err != nil {
+		return fmt.Sprintf("Sorry, an error occurred generating the status of %s: %v", stream, err)
+	}
+	r, ok := rep.Streams[stream]
+	if !ok {
+		return fmt.Sprintf("Sorry, there is no release stream named %s for `%s`", stream, o.Arch)
+	}
+	rep.SlackEmoji = true
+	status := "healthy"
+	if r.Critical() {
+		status = "critical"
+	} else if r.Unhealthy() {
+		status = "unhealthy"
+	}
+	return fmt.Sprintf("Status of `%s` for `%s`: *%s*\n%s", stream, o.Arch, status, rep.StreamString(stream, true))
+}
+
+// postReport posts the subject to the channel (in the thread, if provided) and then posts the msg, if any,
+// as a reply to the subject, in the same thread.
+func (o *options) postReport(subject, msg, channel, thread string) error {
+	ts, err := o.sendMessage(subject, channel, thread)
+	if err != nil {
+		return err
+	}
+	if thread != "" {
+		// the subject is itself a reply, which can't start a thread
+		ts = thread
+	}
+	if msg != "" && o.postAsSnippet(msg) {
+		return o.uploadSnippet(msg, channel, ts)
+	}
+	if msg != "" {
+		if _, err := o.sendMessage(msg, channel, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSchedule posts a report to each of the report channels each time the schedule fires, until the context
+// is done.  The report is generated once, and a failure to post to one channel doesn't stop it being posted
+// to the others.
+func (o *options) runSchedule(ctx context.Context, s *schedule) {
+	for {
+		next := s.next(time.Now())
+		if next.IsZero() {
+			// parseSchedule rejects schedules which never match, so this shouldn't happen, but posting reports in a
+			// tight loop would be much worse than stopping
+			klog.Errorf("schedule has no upcoming time to post a report at, stopping the scheduled reports")
+			return
+		}
+		klog.V(2).Infof("Next scheduled report will be posted to %s at %s", strings.Join(o.reportChannels, ", "), next)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		o.postScheduledReport(ctx)
 	}
 }
 
-func sendMessage(msg, channel, thread string) (string, error) {
+// postScheduledReport posts the report to each of the report channels.  The report comes from the report cache, so
+// a report generated within the cache ttl, e.g. by the warmup or a Slack request for the same report, is reused.
+func (o *options) postScheduledReport(ctx context.Context) {
+	subject, msg := o.reportMessages(ctx, false)
+	failed := []string{}
+	for _, channel := range o.reportChannels {
+		if err := o.postReport(subject, msg, channel, ""); err != nil {
+			klog.Errorf("error posting scheduled report to %s: %v", channel, err)
+			failed = append(failed, channel)
+		}
+	}
+	if len(failed) > 0 {
+		klog.Errorf("scheduled report was not posted to %d of %d channels: %s", len(failed), len(o.reportChannels), strings.Join(failed, ", "))
+	}
+}
+
+const (
+	// Slack allows posting about one message per second to a channel, with short bursts above that
+	slackMessageInterval = time.Second
+	slackMessageBurst    = 3
+)
+
+// slackLimiter limits the rate of messages posted to Slack, so large reports aren't rate limited.
+var slackLimiter = newRateLimiter(slackMessageInterval, slackMessageBurst)
+
+// messageSender posts the message to the channel, in the thread if one is provided, and returns the
+// timestamp (ID) of the posted message.
+type messageSender func(msg, channel, thread string) (string, error)
+
+// postSlackMessage is the messageSender used by the bot, which posts the message using the Slack API.
+func postSlackMessage(msg, channel, thread string) (string, error) {
 	post := PostMessage{}
 	post.Channel = channel
 	// never output our own name, so we don't trigger ourselves
@@ -226,29 +516,23 @@ func sendMessage(msg, channel, thread string) (string, error) {
 
 	postJson, _ := json.Marshal(post)
 
-	fmt.Printf("msg post json: %s\n", postJson)
-	req, err := http.NewRequest("POST", "https://slack.com/api/chat.postMessage", bytes.NewBuffer(postJson))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth_token))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("error posting chat message: %v", err)
-		return "", err
-	}
-	defer resp.Body.Close()
-	// fmt.Printf("chat message response: %#v\n", resp)
-
-	body, err := ioutil.ReadAll(resp.Body)
+	klog.V(5).Infof("msg post json: %s\n", postJson)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", currentAuthToken()))
+	// Slack reports errors in the response body, so the status is only checked for retrying
+	_, body, err := postWithRetry("Slack channel "+channel, "https://slack.com/api/chat.postMessage", postJson, header, slackLimiter.wait)
 	if err != nil {
-		fmt.Printf("error reading message response body: %v\n", err)
 		return "", err
 	}
 	msgResp := PostMessageResponse{}
 	if err := json.Unmarshal([]byte(body), &msgResp); err != nil {
-		fmt.Printf("error reading message response body: %v\n", err)
+		klog.Errorf("error decoding message response body: %v", err)
 		return "", err
 	}
+	if !msgResp.OK {
+		klog.Errorf("Slack rejected message posted to %s: %s", channel, msgResp.Error)
+		return "", fmt.Errorf("error posting message to %s: %s", channel, msgResp.Error)
+	}
 	return msgResp.TS, nil
 }
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+	"github.com/spf13/pflag"
+)
+
+// testPost is a message the bot posted to Slack.
+type testPost struct {
+	msg, channel, thread string
+}
+
+// newTestBot returns the options of a bot which records the messages it posts to posts, rather than posting them
+// to Slack, and clears the messages, threads and reports remembered by previous tests.
+func newTestBot(posts chan<- testPost) *options {
+	mutex.Lock()
+	msgCache = make(map[string]time.Time)
+	threadOptions = make(map[string]threadReport)
+	mutex.Unlock()
+	reports.lock.Lock()
+	reports.reports = nil
+	reports.lock.Unlock()
+
+	o := &options{
+		sendMessage: func(msg, channel, thread string) (string, error) {
+			posts <- testPost{msg: msg, channel: channel, thread: thread}
+			return "1717416000.000200", nil
+		},
+		uploadSnippet: func(report, channel, thread string) error {
+			return fmt.Errorf("unexpected snippet upload to %s", channel)
+		},
+	}
+	// start from the flags' defaults, like the bot command
+	addSharedFlags(pflag.NewFlagSet("bot", pflag.ContinueOnError), o)
+	o.maxRequestBytes = 1 << 20
+	o.OldestMinor, o.NewestMinor = 14, 16
+	o.Clock = watcher.FakeClock{Time: time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)}
+	return o
+}
+
+// newTestReleaseController returns a release controller serving a single healthy 4.15.0-0.nightly stream, which
+// counts the reports generated from it in fetches, if set.
+func newTestReleaseController(fetches *int32) *httptest.Server {
+	payload := "4.15.0-0.nightly-2024-06-03-030000"
+	streams := map[string][]string{"4.15.0-0.nightly": {payload}}
+	graph := map[string]interface{}{
+		"nodes": []map[string]string{{"version": payload}, {"version": "4.15.2"}, {"version": "4.14.9"}},
+		"edges": [][2]int{{1, 0}, {2, 0}},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/releasestreams/accepted", func(w http.ResponseWriter, r *http.Request) {
+		if fetches != nil {
+			atomic.AddInt32(fetches, 1)
+		}
+		json.NewEncoder(w).Encode(streams)
+	})
+	mux.HandleFunc("/api/v1/releasestreams/all", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(streams)
+	})
+	mux.HandleFunc("/graph", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(graph)
+	})
+	return httptest.NewServer(mux)
+}
+
+// deliver posts the request to the handler as Slack would, returning the response.
+func deliver(t *testing.T, handler http.HandlerFunc, req Request, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// waitForPosts returns the next n messages posted, failing the test if they aren't posted in time.
+func waitForPosts(t *testing.T, posts <-chan testPost, n int) []testPost {
+	t.Helper()
+	got := []testPost{}
+	for len(got) < n {
+		select {
+		case p := <-posts:
+			got = append(got, p)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %d messages to be posted, got %d: %v", n, len(got), got)
+		}
+	}
+	return got
+}
+
+// expectNoPosts fails the test if a message is posted soon.
+func expectNoPosts(t *testing.T, posts <-chan testPost) {
+	t.Helper()
+	select {
+	case p := <-posts:
+		t.Fatalf("unexpected message posted to %s: %q", p.channel, p.msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCreateHandlerURLVerification(t *testing.T) {
+	posts := make(chan testPost, 10)
+	o := newTestBot(posts)
+	w := deliver(t, o.createHandler(context.Background()), Request{Type: "url_verification", Challenge: "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-type"); got != "application/json" {
+		t.Errorf("expected a JSON response, got Content-type %q", got)
+	}
+	resp := VerificationResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error decoding the response %q: %v", w.Body.String(), err)
+	}
+	if resp.Challenge != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("expected the challenge to be echoed, got %q", resp.Challenge)
+	}
+	expectNoPosts(t, posts)
+}
+
+func TestCreateHandlerDedup(t *testing.T) {
+	retry := http.Header{"X-Slack-Retry-Num": {"1"}, "X-Slack-Retry-Reason": {"http_timeout"}}
+	tests := []struct {
+		name   string
+		first  Request
+		second Request
+		header http.Header
+		// replies is how many of the deliveries are replied to
+		replies int
+	}{
+		{
+			name:    "retry of the same delivery",
+			first:   Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100", ClientMsgID: "a1"}},
+			second:  Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100", ClientMsgID: "a1"}},
+			header:  retry,
+			replies: 1,
+		},
+		{
+			name:    "same client_msg_id delivered as another event",
+			first:   Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100", ClientMsgID: "a1"}},
+			second:  Request{Type: "event_callback", EventID: "Ev02", Event: Event{Type: "message", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100", ClientMsgID: "a1"}},
+			replies: 1,
+		},
+		{
+			name:    "same ts without a client_msg_id",
+			first:   Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100"}},
+			second:  Request{Type: "event_callback", EventID: "Ev02", Event: Event{Type: "message", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100"}},
+			replies: 1,
+		},
+		{
+			name:    "same event_id without a ts",
+			first:   Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD"}},
+			second:  Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD"}},
+			header:  retry,
+			replies: 1,
+		},
+		{
+			name:    "events without an identifier",
+			first:   Request{Type: "event_callback", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD"}},
+			second:  Request{Type: "event_callback", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD"}},
+			replies: 2,
+		},
+		{
+			name:    "different messages",
+			first:   Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD", TS: "1717416000.000100", ClientMsgID: "a1"}},
+			second:  Request{Type: "event_callback", EventID: "Ev02", Event: Event{Type: "app_mention", Text: "help", Channel: "C0123ABCD", TS: "1717416001.000100", ClientMsgID: "a2"}},
+			replies: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			posts := make(chan testPost, 10)
+			o := newTestBot(posts)
+			handler := o.createHandler(context.Background())
+			if w := deliver(t, handler, tc.first, nil); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200 for the first delivery, got %d", w.Code)
+			}
+			if w := deliver(t, handler, tc.second, tc.header); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200 for the second delivery, got %d", w.Code)
+			}
+			waitForPosts(t, posts, tc.replies)
+			expectNoPosts(t, posts)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if _, found := msgCache[""]; found {
+				t.Errorf("expected no event to be remembered without a key")
+			}
+		})
+	}
+}
+
+func TestCreateHandlerCommands(t *testing.T) {
+	api := newTestReleaseController(nil)
+	defer api.Close()
+
+	tests := []struct {
+		name  string
+		event Event
+		// want are substrings of each message posted in reply, in order
+		want []string
+	}{
+		{
+			name:  "help",
+			event: Event{Text: "<@UE23Q9BFY> help", TS: "1717416000.000100"},
+			want:  []string{"*report* - "},
+		},
+		{
+			name:  "details outside a thread",
+			event: Event{Text: "<@UE23Q9BFY> details 4.15.0-0.nightly", TS: "1717416000.000100"},
+			want:  []string{"Sorry, *details* has to be a reply in the thread of a report"},
+		},
+		{
+			name:  "report with arguments",
+			event: Event{Text: "<@UE23Q9BFY> report min=15 max=15 api=<" + api.URL + "> healthy", TS: "1717416000.000100"},
+			want:  []string{"Latest payload stream health report thread for `amd64`, `v4.15` to `v4.15` (0 of 1 streams unhealthy)", "4.15.0-0.nightly"},
+		},
+		{
+			name:  "report tagging the patch manager",
+			event: Event{Text: "<@UE23Q9BFY> report min=15 max=15 api=<" + api.URL + "|" + api.URL + "> tag", TS: "1717416000.000100"},
+			want:  []string{"(0 of 1 streams unhealthy)", "<!subteam^" + patchmanagerId + "> here are the currently unhealthy payload streams"},
+		},
+		{
+			name:  "report with an invalid argument",
+			event: Event{Text: "<@UE23Q9BFY> report min=fifteen", TS: "1717416000.000100"},
+			want:  []string{`Error parsing min z-stream version value "fifteen"`},
+		},
+		{
+			name:  "report with an invalid range",
+			event: Event{Text: "<@UE23Q9BFY> report min=16 max=15", TS: "1717416000.000100"},
+			want:  []string{"Sorry, I can't generate a report with those arguments"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			posts := make(chan testPost, 10)
+			o := newTestBot(posts)
+			tc.event.Type, tc.event.Channel = "app_mention", "C0123ABCD"
+			deliver(t, o.createHandler(context.Background()), Request{Type: "event_callback", EventID: "Ev01", Event: tc.event}, nil)
+			got := waitForPosts(t, posts, len(tc.want))
+			for i, want := range tc.want {
+				if !strings.Contains(got[i].msg, want) {
+					t.Errorf("expected message %d to contain %q, got %q", i, want, got[i].msg)
+				}
+				if got[i].channel != "C0123ABCD" {
+					t.Errorf("expected message %d to be posted to C0123ABCD, got %s", i, got[i].channel)
+				}
+			}
+			expectNoPosts(t, posts)
+		})
+	}
+}
+
+func TestPostScheduledReport(t *testing.T) {
+	var fetches int32
+	api := newTestReleaseController(&fetches)
+	defer api.Close()
+
+	posts := make(chan testPost, 10)
+	o := newTestBot(posts)
+	o.ReleaseAPIUrl = api.URL
+	o.reportCacheTTL = time.Hour
+	o.reportChannels = []string{"C0123ABCD", "C0456EFGH"}
+
+	// an interactive report without arguments asks for the same report as the schedule
+	deliver(t, o.createHandler(context.Background()), Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "<@UE23Q9BFY> report", Channel: "C0789IJKL", TS: "1717416000.000100"}}, nil)
+	waitForPosts(t, posts, 2)
+
+	o.postScheduledReport(context.Background())
+	got := waitForPosts(t, posts, 4)
+	for i, channel := range []string{"C0123ABCD", "C0123ABCD", "C0456EFGH", "C0456EFGH"} {
+		if got[i].channel != channel {
+			t.Errorf("expected message %d to be posted to %s, got %s", i, channel, got[i].channel)
+		}
+	}
+	if want := "(0 of 1 streams unhealthy)"; !strings.Contains(got[0].msg, want) || got[0].thread != "" {
+		t.Errorf("expected the report summary containing %q to start a thread, got %q in thread %q", want, got[0].msg, got[0].thread)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the scheduled report to reuse the interactive report, the release streams were fetched %d times", n)
+	}
+	expectNoPosts(t, posts)
+}
+
+func TestParseReportArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		token string
+
+		oldest, newest  int
+		arch            string
+		releaseAPIUrl   string
+		include         []string
+		exclude         []string
+		healthy, tag    bool
+		expectedErrText string
+	}{
+		{
+			name:   "no arguments",
+			text:   "<@UE23Q9BFY> report",
+			oldest: 14, newest: 16, arch: "amd64",
+			include: []string{"*.nightly"},
+		},
+		{
+			name:   "minor range",
+			text:   "<@UE23Q9BFY> report min=9 max=12",
+			oldest: 9, newest: 12, arch: "amd64",
+			include: []string{"*.nightly"},
+		},
+		{
+			name:   "arch",
+			text:   "<@UE23Q9BFY> report arch=arm64",
+			oldest: 14, newest: 16, arch: "arm64",
+			include: []string{"*.nightly"},
+		},
+		{
+			name:   "stream patterns add to the bot's",
+			text:   "<@UE23Q9BFY> report include=4.16.* exclude=4.16.0-0.ci exclude=4.15.0-0.ci",
+			oldest: 14, newest: 16, arch: "amd64",
+			include: []string{"*.nightly", "4.16.*"},
+			exclude: []string{"4.16.0-0.ci", "4.15.0-0.ci"},
+		},
+		{
+			name:   "every argument",
+			text:   "<@UE23Q9BFY> report min=9 max=12 healthy tag",
+			oldest: 9, newest: 12, arch: "amd64",
+			include: []string{"*.nightly"},
+			healthy: true, tag: true,
+		},
+		{
+			name:   "api",
+			text:   "<@UE23Q9BFY> report api=https://staging.example.com/rc",
+			oldest: 14, newest: 16, arch: "amd64",
+			releaseAPIUrl: "https://staging.example.com/rc",
+			include:       []string{"*.nightly"},
+		},
+		{
+			name:   "api linked by Slack",
+			text:   "<@UE23Q9BFY> report api=<https://staging.example.com|staging.example.com>",
+			oldest: 14, newest: 16, arch: "amd64",
+			releaseAPIUrl: "https://staging.example.com",
+			include:       []string{"*.nightly"},
+		},
+		{
+			name:            "api which isn't a url",
+			text:            "<@UE23Q9BFY> report api=staging",
+			expectedErrText: `"staging" isn't a release API url`,
+		},
+		{
+			name:            "api when the bot has an API token",
+			text:            "<@UE23Q9BFY> report api=https://staging.example.com",
+			token:           "secret",
+			expectedErrText: "I authenticate to mine with an API token",
+		},
+		{
+			name:            "min which isn't a number",
+			text:            "<@UE23Q9BFY> report min=x",
+			expectedErrText: `Error parsing min z-stream version value "x"`,
+		},
+		{
+			name:            "max which isn't a number",
+			text:            "<@UE23Q9BFY> report max=",
+			expectedErrText: `Error parsing max z-stream version value ""`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &options{includeHealthy: true, stateFile: "/var/lib/release-watcher/state.json", notifyRecoveries: true, pagerDutyRoutingKey: "key"}
+			o.Arch = "amd64"
+			o.OldestMinor, o.NewestMinor = 14, 16
+			o.IncludeStreams = []string{"*.nightly"}
+			o.APIToken = tc.token
+
+			got, tag, err := o.parseReportArgs(tc.text)
+			if tc.expectedErrText != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErrText) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectedErrText, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.OldestMinor != tc.oldest || got.NewestMinor != tc.newest {
+				t.Errorf("expected minors %d to %d, got %d to %d", tc.oldest, tc.newest, got.OldestMinor, got.NewestMinor)
+			}
+			if got.Arch != tc.arch {
+				t.Errorf("expected arch %q, got %q", tc.arch, got.Arch)
+			}
+			if got.ReleaseAPIUrl != tc.releaseAPIUrl {
+				t.Errorf("expected release API url %q, got %q", tc.releaseAPIUrl, got.ReleaseAPIUrl)
+			}
+			if !reflect.DeepEqual(got.IncludeStreams, tc.include) {
+				t.Errorf("expected included streams %v, got %v", tc.include, got.IncludeStreams)
+			}
+			if len(got.ExcludeStreams) != 0 || len(tc.exclude) != 0 {
+				if !reflect.DeepEqual(got.ExcludeStreams, tc.exclude) {
+					t.Errorf("expected excluded streams %v, got %v", tc.exclude, got.ExcludeStreams)
+				}
+			}
+			if got.includeHealthy != tc.healthy {
+				t.Errorf("expected includeHealthy %t, got %t", tc.healthy, got.includeHealthy)
+			}
+			if tag != tc.tag {
+				t.Errorf("expected tag %t, got %t", tc.tag, tag)
+			}
+			// interactive reports aren't tracked
+			if got.stateFile != "" || got.notifyRecoveries || got.pagerDutyRoutingKey != "" {
+				t.Errorf("expected the report not to be tracked, got state file %q, notify recoveries %t, PagerDuty key %q", got.stateFile, got.notifyRecoveries, got.pagerDutyRoutingKey)
+			}
+			// the bot's own options are left as they were
+			if !reflect.DeepEqual(o.IncludeStreams, []string{"*.nightly"}) || o.ExcludeStreams != nil || !o.includeHealthy {
+				t.Errorf("expected the bot's options to be unchanged, got included %v, excluded %v, includeHealthy %t", o.IncludeStreams, o.ExcludeStreams, o.includeHealthy)
+			}
+		})
+	}
+}
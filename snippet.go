@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+const (
+	slackFileUploadUrl = "https://slack.com/api/files.upload"
+
+	// slackMessageLimit is the longest message text Slack accepts, longer messages are truncated
+	slackMessageLimit = 40000
+
+	// snippetFilename is the name reports are uploaded as
+	snippetFilename = "payload-report.txt"
+)
+
+// snippetUploader uploads the report as a text snippet shared in the thread of the channel.
+type snippetUploader func(report, channel, thread string) error
+
+type FileUploadResponse struct {
+	OK bool `json:"ok"`
+	// Error is the Slack error code when OK is false, e.g. "invalid_auth"
+	Error string `json:"error"`
+	File  struct {
+		Permalink string `json:"permalink"`
+	} `json:"file"`
+}
+
+// postAsSnippet returns true if the report should be uploaded as a snippet rather than posted as a message.
+func (o *options) postAsSnippet(report string) bool {
+	return o.asSnippet || (o.snippetThreshold > 0 && len(report) > o.snippetThreshold)
+}
+
+// uploadSlackSnippet is the snippetUploader used by the bot, which uploads the report using the Slack API.
+func uploadSlackSnippet(report, channel, thread string) error {
+	form := neturl.Values{}
+	form.Set("channels", channel)
+	// never output our own name, so we don't trigger ourselves
+	form.Set("content", strings.Replace(report, "@UE23Q9BFY", "OCP Payload Reporter", -1))
+	form.Set("filename", snippetFilename)
+	form.Set("filetype", "text")
+	form.Set("title", "Payload stream health report")
+	form.Set("initial_comment", "The full report is attached as a snippet")
+	if thread != "" {
+		form.Set("thread_ts", thread)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", currentAuthToken()))
+	// Slack reports errors in the response body, so the status is only checked for retrying
+	_, body, err := postWithRetry("Slack channel "+channel, slackFileUploadUrl, []byte(form.Encode()), header, slackLimiter.wait)
+	if err != nil {
+		return err
+	}
+	uploadResp := FileUploadResponse{}
+	if err := json.Unmarshal(body, &uploadResp); err != nil {
+		klog.Errorf("error decoding file upload response body: %v", err)
+		return err
+	}
+	if !uploadResp.OK {
+		klog.Errorf("Slack rejected snippet uploaded to %s: %s", channel, uploadResp.Error)
+		return fmt.Errorf("error uploading snippet to %s: %s", channel, uploadResp.Error)
+	}
+	klog.V(2).Infof("Uploaded report snippet %s to %s\n", uploadResp.File.Permalink, channel)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// tokenFilePollInterval is how often the token file is re-read to pick up a rotated token.
+const tokenFilePollInterval = time.Minute
+
+// authToken holds the Slack token used to post messages.  It is replaced while the bot is running when the
+// token file is rotated.
+var authToken atomic.Value
+
+// currentAuthToken returns the Slack token, or an empty string if none has been loaded.
+func currentAuthToken() string {
+	token, _ := authToken.Load().(string)
+	return token
+}
+
+// readTokenFile reads a token, e.g. the Slack token, from the file, ignoring surrounding whitespace such as a trailing newline.
+func readTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading token file %s: %v", path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// watchTokenFile periodically re-reads the token file until the context is cancelled, replacing the
+// Slack token when the file's contents change.  If the file can't be read, the previous token is kept.
+func watchTokenFile(ctx context.Context, path string) {
+	ticker := time.NewTicker(tokenFilePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		token, err := readTokenFile(path)
+		if err != nil {
+			klog.Errorf("error reloading Slack token, continuing to use the previous token: %v", err)
+			continue
+		}
+		if token != currentAuthToken() {
+			klog.Infof("Slack token file %s changed, using the new token", path)
+			authToken.Store(token)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// warmupRetryInterval is how long to wait before retrying a failed startup warmup.
+const warmupRetryInterval = 30 * time.Second
+
+// ready is set once the bot is ready to serve reports, see warmUp.
+var ready atomic.Bool
+
+// warmUp generates a report with the bot's own options, without posting it, until one succeeds, and then marks the
+// bot ready.  The report primes the report cache, so /readyz only reports ready once the release controller has
+// been reached, and the first Slack, scheduled and /report.json reports asking for the bot's default report are
+// served without waiting for one to be generated.
+func (o *options) warmUp(ctx context.Context) {
+	for {
+		start := time.Now()
+		_, err := o.cachedReport(ctx)
+		if err == nil {
+			klog.V(2).Infof("Warmup report generated in %s, ready to serve reports\n", time.Since(start))
+			ready.Store(true)
+			return
+		}
+		klog.Warningf("Warmup report failed, retrying in %s: %v", warmupRetryInterval, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmupRetryInterval):
+		}
+	}
+}
+
+// readyz responds 200 once the bot is ready to serve reports, and 503 until then.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "warming up", http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
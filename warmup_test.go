@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmUpPrimesReports(t *testing.T) {
+	var fetches int32
+	api := newTestReleaseController(&fetches)
+	defer api.Close()
+	ready.Store(false)
+	defer ready.Store(false)
+
+	posts := make(chan testPost, 10)
+	o := newTestBot(posts)
+	o.ReleaseAPIUrl = api.URL
+	o.reportCacheTTL = time.Hour
+
+	w := httptest.NewRecorder()
+	readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the bot not to be ready before the warmup, got status %d", w.Code)
+	}
+	o.warmUp(context.Background())
+	w = httptest.NewRecorder()
+	readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the bot to be ready after the warmup, got status %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected the warmup to fetch the release streams once, fetched them %d times", n)
+	}
+
+	deliver(t, o.createHandler(context.Background()), Request{Type: "event_callback", EventID: "Ev01", Event: Event{Type: "app_mention", Text: "<@UE23Q9BFY> report", Channel: "C0123ABCD", TS: "1717416000.000100"}}, nil)
+	got := waitForPosts(t, posts, 2)
+	if want := "(0 of 1 streams unhealthy)"; !strings.Contains(got[0].msg, want) {
+		t.Errorf("expected the report summary to contain %q, got %q", want, got[0].msg)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the first report after the warmup to reuse the warmup's report, the release streams were fetched %d times", n)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bparees/release-watcher/pkg/watcher"
+	"k8s.io/klog"
+)
+
+// parseWebhookHeaders parses "Name: value" headers, as given to --webhook-header.
+func parseWebhookHeaders(headers []string) (http.Header, error) {
+	header := http.Header{}
+	for _, h := range headers {
+		name, value, found := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid webhook header %q, must be in the form \"Name: value\"", h)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// notifyWebhook posts the JSON report to the webhook, if one is configured.
+func (o *options) notifyWebhook(rep *watcher.Report) error {
+	if o.webhookURL == "" {
+		return nil
+	}
+	header, err := parseWebhookHeaders(o.webhookHeaders)
+	if err != nil {
+		return err
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	body, err := rep.Format(watcher.FormatJSON, true)
+	if err != nil {
+		return err
+	}
+	status, respBody, err := postWithRetry(o.webhookURL, o.webhookURL, []byte(body), header, nil)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("non-OK http response code from %s: %d: %s", o.webhookURL, status, respBody)
+	}
+	klog.V(4).Infof("Posted the report to %s\n", o.webhookURL)
+	return nil
+}